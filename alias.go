@@ -0,0 +1,222 @@
+// psmcli
+// Copyright (C) 2014 Procera Networks, Inc.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"kastelo.io/psmcli/completion"
+)
+
+// An alias is a user defined command. A plain alias is a name for the
+// start of a command, the rest of the command line following the
+// expansion. A macro has parameters, which are replaced by the arguments
+// wherever they occur in the body.
+type alias struct {
+	name   string
+	params []string
+	body   string
+	macro  bool
+}
+
+func (a alias) String() string {
+	if !a.macro {
+		return "alias " + a.name + " = " + a.body
+	}
+	return "macro " + strings.Join(append([]string{a.name}, a.params...), " ") + " = " + a.body
+}
+
+var (
+	aliasExp   = regexp.MustCompile(`^alias\s+([A-Za-z_][A-Za-z0-9_-]*)\s*=\s*(.*)$`)
+	macroExp   = regexp.MustCompile(`^macro\s+([A-Za-z_][A-Za-z0-9_-]*)((?:\s+\$[A-Za-z_][A-Za-z0-9_]*)*)\s*=\s*(.*)$`)
+	unaliasExp = regexp.MustCompile(`^unalias\s+(\S+)$`)
+	paramExp   = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}|\$([A-Za-z_][A-Za-z0-9_]*)`)
+)
+
+// builtins are the commands handled by psmcli itself, which can't be
+// redefined.
+var builtins = []string{"help", "?", "format", "commands", "set", "raw", "alias", "macro", "unalias", "foreach", "import", "export", "copy", "if", "func", "exit", "where"}
+
+// define handles the alias, macro and unalias commands. It returns false
+// if the line is not one of them.
+func (s *session) define(line string) (handled, ok bool) {
+	var a alias
+	switch {
+	case line == "alias":
+		var names []string
+		for name := range s.aliases {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintln(s.out, s.aliases[name])
+		}
+		return true, true
+
+	case unaliasExp.MatchString(line):
+		name := unaliasExp.FindStringSubmatch(line)[1]
+		if _, ok := s.aliases[name]; !ok {
+			fmt.Fprintln(s.out, "unalias: no alias or macro", name)
+			return true, false
+		}
+		delete(s.aliases, name)
+		return true, true
+
+	case aliasExp.MatchString(line):
+		m := aliasExp.FindStringSubmatch(line)
+		a = alias{name: m[1], body: strings.TrimSpace(m[2])}
+
+	case macroExp.MatchString(line):
+		m := macroExp.FindStringSubmatch(line)
+		a = alias{name: m[1], params: strings.Fields(m[2]), body: strings.TrimSpace(m[3]), macro: true}
+
+	case strings.HasPrefix(line, "alias ") || strings.HasPrefix(line, "macro "):
+		fmt.Fprintln(s.out, "Usage: alias name = command, or macro name $param ... = command")
+		return true, false
+
+	default:
+		return false, false
+	}
+
+	if a.body == "" {
+		fmt.Fprintln(s.out, "missing command for", a.name)
+		return true, false
+	}
+	for _, b := range builtins {
+		if a.name == b {
+			fmt.Fprintln(s.out, a.name, "is a built in command and can't be redefined")
+			return true, false
+		}
+	}
+
+	if s.aliases == nil {
+		s.aliases = make(map[string]alias)
+	}
+	s.aliases[a.name] = a
+	return true, true
+}
+
+// expandAlias replaces a leading alias or macro in the line with its
+// expansion, repeatedly if the expansion starts with another one. It
+// returns false if the line doesn't start with an alias.
+func (s *session) expandAlias(line string) (string, bool, error) {
+	seen := make(map[string]bool)
+	for {
+		name, rest := firstWord(line)
+		a, ok := s.aliases[name]
+		if !ok || seen[name] || s.expanding[name] {
+			return line, len(seen) > 0, nil
+		}
+		seen[name] = true
+
+		if !a.macro {
+			line = strings.TrimSpace(a.body + " " + rest)
+			continue
+		}
+
+		args, err := splitWords(rest)
+		if err != nil {
+			return "", false, err
+		}
+		if len(args) != len(a.params) {
+			return "", false, fmt.Errorf("%s: expected %d arguments (%s), got %d", a.name, len(a.params), strings.Join(a.params, " "), len(args))
+		}
+
+		// The arguments are inserted as given, quotes and all, so that
+		// they're parsed the same way in the expansion.
+		line = paramExp.ReplaceAllStringFunc(a.body, func(ref string) string {
+			m := paramExp.FindStringSubmatch(ref)
+			name := "$" + m[1] + m[2]
+			for i, p := range a.params {
+				if p == name {
+					return args[i]
+				}
+			}
+			return ref
+		})
+	}
+}
+
+// aliasMatchers returns completion matchers for the aliases and macros,
+// sorted by name.
+func (s *session) aliasMatchers() []completion.Matcher {
+	var names []string
+	for name := range s.aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var res []completion.Matcher
+	for _, name := range names {
+		a := s.aliases[name]
+		if !a.macro {
+			res = append(res, s.completer.Alias(a.name, a.body))
+			continue
+		}
+
+		var next []completion.Matcher
+		for i := len(a.params) - 1; i >= 0; i-- {
+			next = []completion.Matcher{&completion.Regexp{
+				Exp:         anyWordExp,
+				Placeholder: a.params[i][1:],
+				Next:        next,
+			}}
+		}
+		res = append(res, &completion.Literal{Value: a.name, Next: next})
+	}
+	return res
+}
+
+var anyWordExp = regexp.MustCompile(`.`)
+
+// configDir returns the directory for psmcli configuration, ~/.psmcli.
+func configDir() string {
+	return filepath.Join(os.Getenv("HOME"), ".psmcli")
+}
+
+// loadConfig reads alias and macro definitions from the file, if it
+// exists. Empty lines, lines starting with # and profiles are ignored.
+func (s *session) loadConfig(name string) error {
+	data, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	// Definitions are made silently; errors are collected.
+
+	out := s.out
+	defer func() {
+		s.out = out
+	}()
+
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for num := 1; sc.Scan(); num++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "profile ") {
+			// Profiles are read by readProfiles before connecting
+			continue
+		}
+
+		var buf bytes.Buffer
+		s.out = &buf
+		handled, ok := s.define(line)
+		if !handled || line == "alias" {
+			return fmt.Errorf("%s:%d: expected an alias or macro definition", name, num)
+		}
+		if !ok {
+			return fmt.Errorf("%s:%d: %s", name, num, strings.TrimSpace(buf.String()))
+		}
+	}
+	return sc.Err()
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpandAlias(t *testing.T) {
+	s, _ := testSession(nil)
+	for _, def := range []string{
+		"alias sub = subscriber getByAid",
+		"alias s = sub",
+		"alias loop = loop again",
+		`macro fixhost $aid $name = object updateByAid subscriber $aid hostName=${name}`,
+		"macro both $a = sub $a && sub $a",
+	} {
+		if handled, ok := s.define(def); !handled || !ok {
+			t.Fatalf("Failed definition %q", def)
+		}
+	}
+
+	testcases := []struct {
+		line     string
+		expanded string
+		ok       bool
+	}{
+		{"sub 1234", "subscriber getByAid 1234", true},
+		{"s 1234", "subscriber getByAid 1234", true},
+		{"loop", "loop again", true},
+		{`fixhost 1234 "my host"`, `object updateByAid subscriber 1234 hostName="my host"`, true},
+		{"both 1", "subscriber getByAid 1 && sub 1", true},
+		{"subscriber getByAid 1234", "subscriber getByAid 1234", false},
+	}
+
+	for _, tc := range testcases {
+		expanded, ok, err := s.expandAlias(tc.line)
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %v", tc.line, err)
+			continue
+		}
+		if expanded != tc.expanded || ok != tc.ok {
+			t.Errorf("Incorrect expansion of %q: %q %v != %q %v", tc.line, expanded, ok, tc.expanded, tc.ok)
+		}
+	}
+
+	if _, _, err := s.expandAlias("fixhost 1234"); err == nil {
+		t.Error("Missing error for macro with too few arguments")
+	}
+}
+
+func TestDefine(t *testing.T) {
+	s, out := testSession(nil)
+	for _, line := range []string{"alias set = foo", "alias where = foo", "macro if $a = foo", "alias x =", "macro m $a", "unalias nothing"} {
+		if handled, ok := s.define(line); !handled || ok {
+			t.Errorf("Incorrect handling of %q: %v %v", line, handled, ok)
+		}
+	}
+	if handled, _ := s.define("system hostname"); handled {
+		t.Error("Command handled as definition")
+	}
+
+	s.define("alias b = system hostname")
+	s.define("macro a $x = system echo $x")
+	out.Reset()
+	s.define("alias")
+	if exp := "macro a $x = system echo $x\nalias b = system hostname\n"; out.String() != exp {
+		t.Errorf("Incorrect listing:\n%s", out)
+	}
+	s.define("unalias b")
+	if _, ok := s.aliases["b"]; ok {
+		t.Error("Alias not removed")
+	}
+}
+
+func TestExecuteAlias(t *testing.T) {
+	var methods []string
+	s, _ := testSession(recordingHandler(&methods))
+	s.define("macro both $a = a ok $a && b fail $a")
+	s.define("alias a = a")
+
+	ok, err := s.execute("both 1 || c ok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := []string{"a.ok", "b.fail", "c.ok"}; !ok || !reflect.DeepEqual(methods, exp) {
+		t.Errorf("Incorrect execution: %v %q", ok, methods)
+	}
+
+	// A definition takes the rest of the line, chained commands and all
+	methods = nil
+	if ok, err := s.execute("macro chain $a = c ok $a && d ok $a"); !ok || err != nil {
+		t.Fatalf("Unexpected failure defining a macro: %v %v", ok, err)
+	}
+	if len(methods) != 0 {
+		t.Errorf("Unexpected commands run while defining a macro: %q", methods)
+	}
+	if _, err := s.execute("chain 1"); err != nil {
+		t.Fatal(err)
+	}
+	if exp := []string{"c.ok", "d.ok"}; !reflect.DeepEqual(methods, exp) {
+		t.Errorf("Incorrect execution of chained macro: %q", methods)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "psmcli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "config")
+	ioutil.WriteFile(name, []byte("# Aliases\n\nalias sub = subscriber getByAid\nmacro m $a = system echo $a\n"), 0644)
+
+	s, _ := testSession(nil)
+	if err := s.loadConfig(name); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.aliases) != 2 {
+		t.Errorf("Incorrect aliases %v", s.aliases)
+	}
+
+	ioutil.WriteFile(name, []byte("alias sub = subscriber getByAid\nsystem hostname\n"), 0644)
+	if err := s.loadConfig(name); err == nil || !strings.Contains(err.Error(), "config:2:") {
+		t.Errorf("Incorrect error %v", err)
+	}
+
+	if err := s.loadConfig(filepath.Join(dir, "missing")); err != nil {
+		t.Errorf("Unexpected error for missing file: %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+// psmcli
+// Copyright (C) 2014 Procera Networks, Inc.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A chainPart is one command of a command line, and the operator joining it
+// to the previous command; "" for the first command, ";", "&&" or "||".
+type chainPart struct {
+	op   string
+	line string
+}
+
+// splitChain splits the line into commands separated by ";", "&&" and "||"
+// outside of quotes, JSON and LDAP filters. As in the shell, the command
+// after "&&" runs only if the previous one succeeded and the command after
+// "||" only if it failed. Empty commands are allowed after ";" only.
+func splitChain(line string) ([]chainPart, error) {
+	var parts []chainPart
+	var quote rune
+	escaped := false
+	depth := 0
+	op, start := "", 0
+
+	add := func(end int, next string) error {
+		cmd := strings.TrimSpace(line[start:end])
+		switch {
+		case cmd != "":
+			parts = append(parts, chainPart{op: op, line: cmd})
+		case next == "&&" || next == "||":
+			return fmt.Errorf("missing command before %s", next)
+		case op == "&&" || op == "||":
+			return fmt.Errorf("missing command after %s", op)
+		}
+		return nil
+	}
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped = true
+		case quote != 0:
+			if rune(c) == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = rune(c)
+		case c == '{' || c == '[' || c == '(':
+			depth++
+		case c == '}' || c == ']' || c == ')':
+			if depth > 0 {
+				depth--
+			}
+		case depth > 0:
+		case c == ';' || strings.HasPrefix(line[i:], "&&") || strings.HasPrefix(line[i:], "||"):
+			next := line[i : i+1]
+			if c != ';' {
+				next = line[i : i+2]
+			}
+			if err := add(i, next); err != nil {
+				return nil, err
+			}
+			op, start = next, i+len(next)
+			i += len(next) - 1
+		}
+	}
+
+	if err := add(len(line), ""); err != nil {
+		return nil, err
+	}
+	return parts, nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitChain(t *testing.T) {
+	testcases := []struct {
+		line  string
+		parts []chainPart
+	}{
+		{"system hostname", []chainPart{{"", "system hostname"}}},
+		{"a b; c d", []chainPart{{"", "a b"}, {";", "c d"}}},
+		{"a b && c d || e f", []chainPart{{"", "a b"}, {"&&", "c d"}, {"||", "e f"}}},
+		{"a b&&c d;", []chainPart{{"", "a b"}, {"&&", "c d"}}},
+		{`a b "x;y" 'p && q' x\;y`, []chainPart{{"", `a b "x;y" 'p && q' x\;y`}}},
+		{`a b {"x": "y;z", "v": [1]} && c d`, []chainPart{{"", `a b {"x": "y;z", "v": [1]}`}, {"&&", "c d"}}},
+		{"a find (&(a=1)(|(b=2)(c=3))) || c d", []chainPart{{"", "a find (&(a=1)(|(b=2)(c=3)))"}, {"||", "c d"}}},
+		{"a b | grep x && c d > f", []chainPart{{"", "a b | grep x"}, {"&&", "c d > f"}}},
+		{"; ;", nil},
+	}
+
+	for _, tc := range testcases {
+		parts, err := splitChain(tc.line)
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %v", tc.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(parts, tc.parts) {
+			t.Errorf("Incorrect split of %q: %q != %q", tc.line, parts, tc.parts)
+		}
+	}
+
+	for _, line := range []string{"&& a b", "a b ||", "a b && ; c d", "a b || && c d"} {
+		if _, err := splitChain(line); err == nil {
+			t.Errorf("Missing error for %q", line)
+		}
+	}
+}
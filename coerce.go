@@ -0,0 +1,185 @@
+// psmcli
+// Copyright (C) 2014 Procera Networks, Inc.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// jsonNumberExp matches numbers in JSON syntax.
+var jsonNumberExp = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+
+// isJSONNumber returns true if the string is a number in JSON syntax.
+func isJSONNumber(s string) bool {
+	return jsonNumberExp.MatchString(s)
+}
+
+// objectAttributeTypes are the JSON types of the attributes all objects
+// have. The types of other attributes are those of the values in objects
+// on the node; see valueTypes.
+var objectAttributeTypes = map[string]string{
+	"oid":       "integer",
+	"parentOid": "integer",
+}
+
+// valueTypes returns the JSON types, as named in the SMD, of the values
+// of the objects' attributes, together with objectAttributeTypes. Null
+// values don't tell the type.
+func valueTypes(objs []map[string]interface{}) map[string]string {
+	types := make(map[string]string)
+	for k, typ := range objectAttributeTypes {
+		types[k] = typ
+	}
+	for _, obj := range objs {
+		for k, v := range obj {
+			if _, ok := types[k]; ok {
+				continue
+			}
+			switch v := v.(type) {
+			case json.Number:
+				if strings.ContainsAny(string(v), ".eE") {
+					types[k] = "number"
+				} else {
+					types[k] = "integer"
+				}
+			case bool:
+				types[k] = "boolean"
+			case string:
+				types[k] = "string"
+			case []interface{}:
+				types[k] = "array"
+			case map[string]interface{}:
+				types[k] = "object"
+			}
+		}
+	}
+	return types
+}
+
+// coerceParams converts the parameters of the command to the JSON types
+// declared for the method in the SMD, and the values given for attributes
+// in key=val syntax to the types given. Commands for methods not in the
+// SMD are left untouched. An error is returned for values that can't be
+// converted.
+func coerceParams(cmd *command, services map[string]smdService, types map[string]string) error {
+	svc, ok := services[cmd.Method]
+	if !ok {
+		return nil
+	}
+
+	for i, param := range cmd.Params {
+		if i >= len(svc.Parameters) {
+			break
+		}
+		p := svc.Parameters[i]
+		v, err := coerceValue(param, p.Type, types)
+		if err != nil {
+			return fmt.Errorf("parameter %s: %v", p.Name, err)
+		}
+		cmd.Params[i] = v
+	}
+
+	return nil
+}
+
+// coerceValue converts the value as given on the command line to the named
+// JSON type, and key=val attributes to the attribute types.
+func coerceValue(v interface{}, typ string, types map[string]string) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		return coerceString(v, typ)
+
+	case attributes:
+		if typ != "object" && typ != "any" && typ != "" {
+			return nil, fmt.Errorf("expected %s, not an object", typ)
+		}
+		return coerceAttributes(v, types)
+	}
+
+	// Values given in JSON syntax or as typed literals already have the
+	// types the user intended
+	return v, nil
+}
+
+// coerceString converts a plain word to the named JSON type.
+func coerceString(s, typ string) (interface{}, error) {
+	switch typ {
+	case "integer":
+		// The number is formatted anew, as not all integers strconv
+		// accepts, such as "+5" and "007", are valid JSON
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return json.Number(strconv.FormatInt(i, 10)), nil
+		}
+		if u, err := strconv.ParseUint(s, 10, 64); err == nil {
+			return json.Number(strconv.FormatUint(u, 10)), nil
+		}
+		return nil, fmt.Errorf("%q is not an integer", s)
+
+	case "number":
+		if isJSONNumber(s) {
+			// Sent as is, without loss of precision
+			return json.Number(s), nil
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+			return nil, fmt.Errorf("%q is not a number", s)
+		}
+		return json.Number(strconv.FormatFloat(f, 'g', -1, 64)), nil
+
+	case "boolean":
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a boolean", s)
+		}
+		return b, nil
+
+	case "array":
+		// A plain word given for an array is a comma separated list
+		var res []interface{}
+		for _, e := range strings.Split(s, ",") {
+			res = append(res, e)
+		}
+		return res, nil
+
+	case "object":
+		return nil, fmt.Errorf("%q is not an object", s)
+	}
+
+	return s, nil
+}
+
+// coerceAttributes converts the plain string values of attributes in a
+// key=val object to their types; those of unknown type are left as
+// strings.
+func coerceAttributes(obj attributes, types map[string]string) (attributes, error) {
+	res := make(attributes, len(obj))
+	for k, v := range obj {
+		typ, ok := types[k]
+		if !ok {
+			res[k] = v
+			continue
+		}
+		s, ok := v.(string)
+		if !ok {
+			res[k] = v
+			continue
+		}
+		if s == "null" {
+			// Clearing an attribute, e.g. parentOid=null
+			res[k] = nil
+			continue
+		}
+		cv, err := coerceString(s, typ)
+		if err != nil {
+			return nil, fmt.Errorf("attribute %s: %v", k, err)
+		}
+		res[k] = cv
+	}
+	return res, nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestCoerceParams(t *testing.T) {
+	services := map[string]smdService{
+		"subscriber.list": {
+			Parameters: []smdParameter{
+				{Name: "count", Type: "integer"},
+			},
+		},
+		"object.updateByAid": {
+			Parameters: []smdParameter{
+				{Name: "type", Type: "string"},
+				{Name: "aid", Type: "string"},
+				{Name: "attributes", Type: "object"},
+				{Name: "create", Type: "boolean", Optional: true},
+			},
+		},
+	}
+
+	// The types of subscriber attributes, as from an object on the node
+	types := valueTypes([]map[string]interface{}{
+		{"oid": json.Number("1"), "persistent": true, "slot": nil, "hostName": "web1"},
+		{"slot": json.Number("5"), "parentOid": nil},
+	})
+
+	testcases := []struct {
+		line   string
+		params []interface{}
+	}{
+		{
+			"subscriber list 1",
+			[]interface{}{json.Number("1")},
+		},
+		{
+			"subscriber list 288230376151715606",
+			[]interface{}{json.Number("288230376151715606")},
+		},
+		{
+			"object updateByAid subscriber 1234 persistent=false,slot=80,hostName=foo true",
+			[]interface{}{"subscriber", "1234", attributes{"persistent": false, "slot": json.Number("80"), "hostName": "foo"}, true},
+		},
+		{
+			`object updateByAid subscriber 1234 {"persistent": "false"}`,
+			[]interface{}{"subscriber", "1234", map[string]interface{}{"persistent": "false"}},
+		},
+		{
+			"object updateByAid subscriber str:1234 slot=str:80,parentOid=null",
+			[]interface{}{"subscriber", typedString("1234"), attributes{"slot": typedString("80"), "parentOid": nil}},
+		},
+		{
+			"subscriber list +5",
+			[]interface{}{json.Number("5")},
+		},
+		{
+			"object updateByAid subscriber 1234 slot=007",
+			[]interface{}{"subscriber", "1234", attributes{"slot": json.Number("7")}},
+		},
+		{
+			"system hostname extra",
+			[]interface{}{"extra"},
+		},
+	}
+
+	for _, tc := range testcases {
+		cmd, err := parseCommand(tc.line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := coerceParams(&cmd, services, types); err != nil {
+			t.Errorf("Unexpected error for %q: %v", tc.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(cmd.Params, tc.params) {
+			t.Errorf("Incorrect coercion of %q:\n\t%#v\n\t%#v", tc.line, cmd.Params, tc.params)
+		}
+	}
+
+	for _, line := range []string{
+		"subscriber list many",
+		"object updateByAid subscriber 1234 persistent=maybe",
+		"object updateByAid subscriber 1234 hostName",
+		"object updateByAid subscriber 1234 a=b yes",
+	} {
+		cmd, err := parseCommand(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := coerceParams(&cmd, services, types); err == nil {
+			t.Errorf("Unexpected nil error for %q", line)
+		}
+	}
+}
+
+func TestCoerceNumber(t *testing.T) {
+	testcases := []struct {
+		s   string
+		v   interface{}
+		err bool
+	}{
+		{"1.5", json.Number("1.5"), false},
+		{"-0.25e10", json.Number("-0.25e10"), false},
+		{"12345678901234567890", json.Number("12345678901234567890"), false},
+		{"+5", json.Number("5"), false},
+		{"007", json.Number("7"), false},
+		{".5", json.Number("0.5"), false},
+		{"1.", json.Number("1"), false},
+		{"inf", nil, true},
+		{"NaN", nil, true},
+		{"five", nil, true},
+	}
+
+	for _, tc := range testcases {
+		v, err := coerceString(tc.s, "number")
+		if tc.err {
+			if err == nil {
+				t.Errorf("Unexpected nil error for %q", tc.s)
+			}
+			continue
+		}
+		if err != nil || v != tc.v {
+			t.Errorf("Incorrect coercion of %q: %#v, %v != expected %#v", tc.s, v, err, tc.v)
+		}
+		if _, err := json.Marshal(v); err != nil {
+			t.Errorf("Coercion of %q isn't valid JSON: %v", tc.s, err)
+		}
+	}
+}
+
+func TestRunLineAttributeTypes(t *testing.T) {
+	var params []interface{}
+	s, out := testSession(func(cmd command) (interface{}, int) {
+		switch cmd.Method {
+		case "subscriber.list":
+			return []interface{}{map[string]interface{}{"subscriberId": "9", "slot": 1, "hostName": "web1"}}, 0
+		case methodUpdateByAid:
+			params = cmd.Params
+		}
+		return nil, 0
+	})
+	s.services = map[string]smdService{
+		"subscriber.list": {Parameters: []smdParameter{{Name: "limit"}, {Name: "offset"}}},
+		"object.updateByAid": {Parameters: []smdParameter{
+			{Name: "type", Type: "string"},
+			{Name: "aid", Type: "string"},
+			{Name: "attributes", Type: "object"},
+		}},
+	}
+
+	// The attribute types are those of the subscribers on the node
+	if _, ok, err := s.runLine("object updateByAid subscriber 1 slot=3,hostName=42", false); !ok || err != nil {
+		t.Fatalf("Unexpected failure: %v %v\n%s", ok, err, out)
+	}
+	exp := []interface{}{"subscriber", "1", map[string]interface{}{"slot": json.Number("3"), "hostName": "42"}}
+	if !reflect.DeepEqual(params, exp) {
+		t.Errorf("Incorrect parameters %#v != expected %#v", params, exp)
+	}
+}
@@ -0,0 +1,222 @@
+// psmcli
+// Copyright (C) 2014 Procera Networks, Inc.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const defaultColorTheme = "key=blue,string=green,number=cyan,bool=yellow,null=magenta"
+
+// A colorTheme names the terminal colour used for each kind of JSON token.
+// An empty name means the token is printed without colour.
+type colorTheme struct {
+	Key    string
+	String string
+	Number string
+	Bool   string
+	Null   string
+}
+
+// parseColorTheme parses a theme in the key=color,key=color syntax, for
+// example "key=blue,string=green". Token kinds not mentioned are left
+// uncoloured.
+func parseColorTheme(spec string) (colorTheme, error) {
+	var t colorTheme
+	if spec == "" {
+		return t, nil
+	}
+	for _, part := range strings.Split(spec, ",") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return colorTheme{}, fmt.Errorf("theme: %q is not of the form token=color", part)
+		}
+		color := strings.ToLower(strings.TrimSpace(kv[1]))
+		if _, ok := colorCode(&terminal.EscapeCodes{}, color); !ok {
+			return colorTheme{}, fmt.Errorf("theme: unknown color %q", kv[1])
+		}
+		switch strings.ToLower(strings.TrimSpace(kv[0])) {
+		case "key":
+			t.Key = color
+		case "string":
+			t.String = color
+		case "number":
+			t.Number = color
+		case "bool":
+			t.Bool = color
+		case "null":
+			t.Null = color
+		default:
+			return colorTheme{}, fmt.Errorf("theme: unknown token type %q", kv[0])
+		}
+	}
+	return t, nil
+}
+
+// colorCode returns the escape code for the named colour.
+func colorCode(esc *terminal.EscapeCodes, name string) ([]byte, bool) {
+	switch name {
+	case "", "none":
+		return nil, true
+	case "black":
+		return esc.Black, true
+	case "red":
+		return esc.Red, true
+	case "green":
+		return esc.Green, true
+	case "yellow":
+		return esc.Yellow, true
+	case "blue":
+		return esc.Blue, true
+	case "magenta":
+		return esc.Magenta, true
+	case "cyan":
+		return esc.Cyan, true
+	case "white":
+		return esc.White, true
+	}
+	return nil, false
+}
+
+// useColor returns true if output to the given file should be coloured,
+// i.e. it's a terminal and the user hasn't asked for no colour by setting
+// NO_COLOR.
+func useColor(fd uintptr) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	return terminal.IsTerminal(int(fd))
+}
+
+// Output formats for responses. The json format is the decoded result
+// printed as indented JSON, human is the same with timestamps made
+// readable (see humanize), and raw is the result exactly as sent by PSM.
+const (
+	formatJSON  = "json"
+	formatHuman = "human"
+	formatRaw   = "raw"
+)
+
+// A jsonRenderer prints values as indented JSON, coloured according to the
+// theme. A nil esc disables colouring. The format selects how responses
+// are printed.
+type jsonRenderer struct {
+	esc    *terminal.EscapeCodes
+	theme  colorTheme
+	format string
+}
+
+// render writes the value as indented JSON followed by a newline.
+func (r jsonRenderer) render(out io.Writer, v interface{}) error {
+	bs, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		return err
+	}
+	r.write(out, bs)
+	fmt.Fprintln(out)
+	return nil
+}
+
+// write writes already encoded JSON, adding colour escapes around tokens.
+func (r jsonRenderer) write(out io.Writer, bs []byte) {
+	if r.esc == nil {
+		out.Write(bs)
+		return
+	}
+	out.Write(colorizeJSON(bs, r.esc, r.theme))
+}
+
+// colorizeJSON returns a copy of the JSON document src with each key,
+// string, number, boolean and null token wrapped in the escape codes
+// given by the theme. Whitespace and punctuation are passed through
+// untouched, so the formatting of src is preserved.
+func colorizeJSON(src []byte, esc *terminal.EscapeCodes, theme colorTheme) []byte {
+	var buf bytes.Buffer
+	emit := func(color string, token []byte) {
+		code, _ := colorCode(esc, color)
+		if len(code) == 0 {
+			buf.Write(token)
+			return
+		}
+		buf.Write(code)
+		buf.Write(token)
+		buf.Write(esc.Reset)
+	}
+
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '"':
+			end := stringEnd(src, i)
+			color := theme.String
+			if isKey(src, end) {
+				color = theme.Key
+			}
+			emit(color, src[i:end])
+			i = end
+
+		case c == '-' || c >= '0' && c <= '9':
+			end := i + 1
+			for end < len(src) && strings.IndexByte("0123456789+-.eE", src[end]) >= 0 {
+				end++
+			}
+			emit(theme.Number, src[i:end])
+			i = end
+
+		case bytes.HasPrefix(src[i:], []byte("true")):
+			emit(theme.Bool, src[i:i+4])
+			i += 4
+
+		case bytes.HasPrefix(src[i:], []byte("false")):
+			emit(theme.Bool, src[i:i+5])
+			i += 5
+
+		case bytes.HasPrefix(src[i:], []byte("null")):
+			emit(theme.Null, src[i:i+4])
+			i += 4
+
+		default:
+			buf.WriteByte(c)
+			i++
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// stringEnd returns the index just past the closing quote of the string
+// starting at src[start].
+func stringEnd(src []byte, start int) int {
+	for i := start + 1; i < len(src); i++ {
+		switch src[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+	return len(src)
+}
+
+// isKey returns true if the next non space character at or after pos is a
+// colon, meaning the preceding string is an object key.
+func isKey(src []byte, pos int) bool {
+	for ; pos < len(src); pos++ {
+		switch src[pos] {
+		case ' ', '\t', '\r', '\n':
+			continue
+		case ':':
+			return true
+		}
+		return false
+	}
+	return false
+}
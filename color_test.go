@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+func TestColorizeJSON(t *testing.T) {
+	esc := &terminal.EscapeCodes{
+		Red:    []byte("<r>"),
+		Green:  []byte("<g>"),
+		Yellow: []byte("<y>"),
+		Blue:   []byte("<b>"),
+		Cyan:   []byte("<c>"),
+		Reset:  []byte("</>"),
+	}
+	theme := colorTheme{Key: "blue", String: "green", Number: "cyan", Bool: "yellow", Null: "red"}
+
+	testcases := []struct {
+		in, out string
+	}{
+		{`"foo"`, `<g>"foo"</>`},
+		{`{"a": "b"}`, `{<b>"a"</>: <g>"b"</>}`},
+		{`{"a\"": -1.5e3, "b" : true}`, `{<b>"a\""</>: <c>-1.5e3</>, <b>"b"</> : <y>true</>}`},
+		{`[null, false, 288230376151715606]`, `[<r>null</>, <y>false</>, <c>288230376151715606</>]`},
+	}
+
+	for _, tc := range testcases {
+		res := string(colorizeJSON([]byte(tc.in), esc, theme))
+		if res != tc.out {
+			t.Errorf("Incorrect colorization of %s:\n\t%s\n\t%s", tc.in, res, tc.out)
+		}
+	}
+}
+
+func TestParseColorTheme(t *testing.T) {
+	theme, err := parseColorTheme("key=red,null=none")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if theme != (colorTheme{Key: "red", Null: "none"}) {
+		t.Errorf("Incorrect theme %+v", theme)
+	}
+
+	for _, spec := range []string{"key", "key=pink", "foo=red"} {
+		if _, err := parseColorTheme(spec); err == nil {
+			t.Errorf("Unexpected nil error for %q", spec)
+		}
+	}
+}
@@ -39,6 +39,7 @@ func importSMD(services map[string]smdService) []completion.Matcher {
 			root.AddNext(cmd)
 		}
 
+		var named []completion.NamedParam
 		var cur completion.Matcher = cmd
 		for _, param := range services[svc].Parameters {
 			if param.Name == "type" && !param.Optional && param.Type == "string" {
@@ -51,6 +52,7 @@ func importSMD(services map[string]smdService) []completion.Matcher {
 				}
 				cur.AddNext(s)
 				cur = s
+				named = append(named, completion.NamedParam{Name: param.Name, Value: s})
 			} else {
 				var m completion.Matcher
 				switch param.Type {
@@ -65,12 +67,19 @@ func importSMD(services map[string]smdService) []completion.Matcher {
 						Exp:         regexp.MustCompile(`.`),
 						Placeholder: param.Name + " (" + param.Type + ")",
 						Optional:    param.Optional,
+						FilePrefix:  "@",
 					}
 				}
 				cur.AddNext(m)
 				cur = m
+				named = append(named, completion.NamedParam{Name: param.Name, Value: m})
 			}
 		}
+
+		// Parameters may also be given by name, in any order
+		if len(named) > 0 {
+			cmd.AddNext(&completion.Named{Params: named})
+		}
 	}
 
 	return matchers
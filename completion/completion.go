@@ -3,8 +3,11 @@ package completion
 import (
 	"fmt"
 	"io"
+	"io/ioutil"
+	"path/filepath"
 	"regexp"
 	"strings"
+	"unicode"
 
 	"golang.org/x/crypto/ssh/terminal"
 )
@@ -12,12 +15,20 @@ import (
 // A Completer provides line completion functionality based on Matchers.
 type Completer struct {
 	matchers []Matcher
+
+	// Variables, if set, returns the names of the variables to complete
+	// after a "$" in the last word.
+	Variables func() []string
+
+	// Commands, if set, returns further matchers for the first word, such
+	// as user defined commands.
+	Commands func() []Matcher
 }
 
 // NewCompleter returns a new Completer based on the aggregation of the given
 // Matchers.
 func NewCompleter(m ...Matcher) Completer {
-	return Completer{m}
+	return Completer{matchers: m}
 }
 
 // Complete returns the possible continuation of the given line.
@@ -25,11 +36,18 @@ func (c Completer) Complete(line string, pos int) (head string, comps []Word, ta
 	line, tail = line[:pos], line[pos:]
 
 	words := strings.Split(line, " ")
+	if vars := c.matchVariables(words[len(words)-1]); vars != nil {
+		head = strings.Join(words[:len(words)-1], " ")
+		if len(words) > 1 {
+			head += " "
+		}
+		return head, vars, tail
+	}
 	if len(words) == 1 {
-		return "", aggrMatch(c.matchers, words[0]), tail
+		return "", aggrMatch(c.all(), words[0]), tail
 	}
 
-	matchers := c.matchers
+	matchers := c.all()
 	for _, word := range words[:len(words)-1] {
 		_, matchers = aggrAccept(matchers, word)
 	}
@@ -38,8 +56,55 @@ func (c Completer) Complete(line string, pos int) (head string, comps []Word, ta
 	return head, aggrMatch(matchers, words[len(words)-1]), tail
 }
 
+// matchVariables returns the completions of a variable name after the last
+// "$" in the word, or nil if the word doesn't end in a variable name.
+func (c Completer) matchVariables(word string) []Word {
+	if c.Variables == nil {
+		return nil
+	}
+	idx := strings.LastIndex(word, "$")
+	if idx < 0 {
+		return nil
+	}
+	prefix, partial := word[:idx+1], strings.TrimPrefix(word[idx+1:], "{")
+	if len(partial) < len(word)-idx-1 {
+		prefix += "{"
+	}
+	for _, r := range partial {
+		if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return nil
+		}
+	}
+
+	var res []Word
+	for _, name := range c.Variables() {
+		if strings.HasPrefix(name, partial) {
+			res = append(res, Word{prefix + name, false})
+		}
+	}
+	return res
+}
+
+// all returns the matchers for the first word.
+func (c Completer) all() []Matcher {
+	if c.Commands == nil {
+		return c.matchers
+	}
+	return append(c.Commands(), c.matchers...)
+}
+
+// Alias returns a matcher for the name which continues like the words of
+// the expansion would.
+func (c Completer) Alias(name, expansion string) Matcher {
+	matchers := c.matchers
+	for _, word := range strings.Fields(expansion) {
+		_, matchers = aggrAccept(matchers, word)
+	}
+	return &Literal{Value: name, Next: matchers}
+}
+
 func (c Completer) PrintHelp(out io.Writer, esc *terminal.EscapeCodes) {
-	for _, m := range c.matchers {
+	for _, m := range c.all() {
 		for _, l := range m.Help(esc) {
 			fmt.Fprintln(out, l)
 		}
@@ -113,7 +178,12 @@ func (c *CallbackCompleter) Complete(line string, pos int, key rune) (string, in
 		return line, pos, false
 	}
 	if len(words) == 1 && !words[0].Placeholder {
-		line = head + words[0].Value + " "
+		line = head + words[0].Value
+		if !strings.HasSuffix(line, "/") && !strings.HasSuffix(line, "=") {
+			// Directories and parameter names are likely to be
+			// continued, other words not.
+			line += " "
+		}
 		return line, len(line), true
 	}
 
@@ -181,15 +251,20 @@ func (l *Literal) Help(esc *terminal.EscapeCodes) []string {
 	return lines
 }
 
-// Regexp matches any word that matches Exp.
+// Regexp matches any word that matches Exp. If FilePrefix is set, words
+// starting with it are completed as local file names.
 type Regexp struct {
 	Exp         *regexp.Regexp
 	Placeholder string
 	Optional    bool
+	FilePrefix  string
 	Next        []Matcher
 }
 
 func (r *Regexp) Match(word string) []Word {
+	if r.FilePrefix != "" && strings.HasPrefix(word, r.FilePrefix) {
+		return matchFiles(r.FilePrefix, word[len(r.FilePrefix):])
+	}
 	p := "<" + r.Placeholder + ">"
 	if r.Optional {
 		p = "[" + r.Placeholder + "]"
@@ -234,6 +309,63 @@ func (r *Regexp) Help(esc *terminal.EscapeCodes) []string {
 	return lines
 }
 
+// Named matches parameters given by name, as --name=value or --name:value,
+// in any order. Names are completed, as are values where the parameter's
+// Value matcher offers choices. Accepted words lead back to the Named
+// matcher itself, so that any number of named parameters can follow each
+// other.
+type Named struct {
+	Params []NamedParam
+	Next   []Matcher
+}
+
+// A NamedParam is a parameter name and the Matcher for its value.
+type NamedParam struct {
+	Name  string
+	Value Matcher
+}
+
+func (n *Named) Match(word string) []Word {
+	if word == "" || !strings.HasPrefix(word, "--") && !strings.HasPrefix("--", word) {
+		return nil
+	}
+
+	var res []Word
+	for _, p := range n.Params {
+		prefix := "--" + p.Name + "="
+		switch {
+		case strings.HasPrefix(prefix, word):
+			res = append(res, Word{prefix, false})
+		case strings.HasPrefix(word, prefix) && p.Value != nil:
+			for _, w := range p.Value.Match(word[len(prefix):]) {
+				if !w.Placeholder {
+					res = append(res, Word{prefix + w.Value, false})
+				}
+			}
+		}
+	}
+	return res
+}
+
+func (n *Named) Accept(word string) (bool, []Matcher) {
+	for _, p := range n.Params {
+		if strings.HasPrefix(word, "--"+p.Name+"=") || strings.HasPrefix(word, "--"+p.Name+":") {
+			return true, append([]Matcher{n}, n.Next...)
+		}
+	}
+	return false, nil
+}
+
+func (n *Named) AddNext(m Matcher) {
+	n.Next = append(n.Next, m)
+}
+
+// Help returns nothing, as the positional form of the parameters is
+// already described by the other Matchers.
+func (n *Named) Help(esc *terminal.EscapeCodes) []string {
+	return nil
+}
+
 // Combine aggregates the matches of it's children. If any of the Matchers
 // match, Accept will return all the Next. Use this when you have multiple
 // choices that all result in the same continuation, e.g.:
@@ -283,6 +415,35 @@ func (s *Combine) Help(esc *terminal.EscapeCodes) []string {
 	return lines
 }
 
+// matchFiles returns the files and directories starting with the given
+// path, each with the prefix prepended. Directories get a trailing slash.
+// Hidden files are only included when the name to complete starts with a
+// dot.
+func matchFiles(prefix, path string) []Word {
+	dir, base := filepath.Split(path)
+	readDir := dir
+	if readDir == "" {
+		readDir = "."
+	}
+	infos, err := ioutil.ReadDir(readDir)
+	if err != nil {
+		return nil
+	}
+
+	var res []Word
+	for _, info := range infos {
+		name := info.Name()
+		if !strings.HasPrefix(name, base) || strings.HasPrefix(name, ".") && !strings.HasPrefix(base, ".") {
+			continue
+		}
+		if info.IsDir() {
+			name += "/"
+		}
+		res = append(res, Word{prefix + dir + name, false})
+	}
+	return res
+}
+
 func aggrMatch(ms []Matcher, word string) []Word {
 	var res []Word
 	for _, m := range ms {
@@ -1,9 +1,16 @@
 package completion
 
 import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
 	"regexp"
+	"strings"
 	"testing"
+
+	"golang.org/x/crypto/ssh/terminal"
 )
 
 var _ Matcher = &Literal{}
@@ -177,3 +184,148 @@ func TestCallbackCompleter(t *testing.T) {
 		}
 	}
 }
+
+func TestRegexpFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "completion")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Mkdir(filepath.Join(dir, "objs"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "obj.json"), nil, 0644)
+	ioutil.WriteFile(filepath.Join(dir, ".hidden"), nil, 0644)
+
+	r := &Regexp{
+		Exp:         regexp.MustCompile(`.`),
+		Placeholder: "attributes",
+		FilePrefix:  "@",
+	}
+
+	testcases := []struct {
+		input   string
+		matches []Word
+	}{
+		{"", []Word{{"<attributes>", true}}},
+		{"foo", []Word{{"foo", false}}},
+		{"@" + dir + "/o", []Word{{"@" + dir + "/obj.json", false}, {"@" + dir + "/objs/", false}}},
+		{"@" + dir + "/objs", []Word{{"@" + dir + "/objs/", false}}},
+		{"@" + dir + "/.", []Word{{"@" + dir + "/.hidden", false}}},
+		{"@" + dir + "/x", nil},
+	}
+
+	for _, tc := range testcases {
+		m := r.Match(tc.input)
+		if !reflect.DeepEqual(m, tc.matches) {
+			t.Errorf("Incorrect match: input %q, matches: %+v != %+v", tc.input, m, tc.matches)
+		}
+	}
+}
+
+func TestNamed(t *testing.T) {
+	typ := &Combine{
+		Matchers: []Matcher{
+			&Literal{Value: "session"},
+			&Literal{Value: "subscriber"},
+		},
+	}
+	aid := &Regexp{
+		Exp:         regexp.MustCompile(`.`),
+		Placeholder: "aid",
+	}
+	typ.AddNext(aid)
+	cmd := &Literal{Value: "update", Next: []Matcher{typ}}
+	cmd.AddNext(&Named{Params: []NamedParam{{"type", typ}, {"aid", aid}}})
+
+	type res struct {
+		head  string
+		comps []string
+	}
+	testcases := []struct {
+		line string
+		res
+	}{
+		{"update ", res{"update ", []string{"session", "subscriber"}}},
+		{"update -", res{"update ", []string{"--type=", "--aid="}}},
+		{"update --a", res{"update ", []string{"--aid="}}},
+		{"update --type=s", res{"update ", []string{"--type=session", "--type=subscriber"}}},
+		{"update --aid=", res{"update ", []string{"--aid="}}},
+		{"update --aid=1234 --t", res{"update --aid=1234 ", []string{"--type="}}},
+	}
+
+	c := NewWordCompleter(cmd)
+	for _, tc := range testcases {
+		head, comps, _ := c.Complete(tc.line, len(tc.line))
+		if head != tc.head || !reflect.DeepEqual(comps, tc.comps) {
+			t.Errorf("Incorrect completion of %q: %q %q != %q %q", tc.line, head, comps, tc.head, tc.comps)
+		}
+	}
+
+	cc := NewCallbackCompleter(cmd)
+	line, pos, _ := cc.Complete("update --a", 10, '\t')
+	if line != "update --aid=" || pos != 13 {
+		t.Errorf("Incorrect callback completion: %q %d", line, pos)
+	}
+}
+
+func TestVariables(t *testing.T) {
+	cmd := &Literal{Value: "get", Next: []Matcher{&Regexp{Exp: regexp.MustCompile(`.`), Placeholder: "oid"}}}
+
+	type res struct {
+		head  string
+		comps []string
+	}
+	testcases := []struct {
+		line string
+		res
+	}{
+		{"get $", res{"get ", []string{"$_", "$sub", "$subs"}}},
+		{"get $su", res{"get ", []string{"$sub", "$subs"}}},
+		{"get oid=${s", res{"get ", []string{"oid=${sub", "oid=${subs"}}},
+		{"get $x", res{"get ", []string{"$x"}}},
+		{"get $_.o", res{"get ", []string{"$_.o"}}},
+	}
+
+	c := NewWordCompleter(cmd)
+	c.Variables = func() []string { return []string{"_", "sub", "subs"} }
+	for _, tc := range testcases {
+		head, comps, _ := c.Complete(tc.line, len(tc.line))
+		if head != tc.head || !reflect.DeepEqual(comps, tc.comps) {
+			t.Errorf("Incorrect completion of %q: %q %q != %q %q", tc.line, head, comps, tc.head, tc.comps)
+		}
+	}
+}
+
+func TestCommands(t *testing.T) {
+	aid := &Regexp{Exp: regexp.MustCompile(`.`), Placeholder: "aid"}
+	typ := &Literal{Value: "getByAid", Next: []Matcher{aid}}
+	cmd := &Literal{Value: "subscriber", Next: []Matcher{typ}}
+
+	c := NewWordCompleter(cmd)
+	c.Commands = func() []Matcher {
+		return []Matcher{c.Alias("sub", "subscriber getByAid"), &Literal{Value: "status"}}
+	}
+
+	testcases := []struct {
+		line  string
+		comps []string
+	}{
+		{"s", []string{"sub", "status", "subscriber"}},
+		{"sub", []string{"sub", "subscriber"}},
+		{"sub ", []string{"<aid>"}},
+		{"subscriber ", []string{"getByAid"}},
+	}
+
+	for _, tc := range testcases {
+		_, comps, _ := c.Complete(tc.line, len(tc.line))
+		if !reflect.DeepEqual(comps, tc.comps) {
+			t.Errorf("Incorrect completion of %q: %q != %q", tc.line, comps, tc.comps)
+		}
+	}
+
+	var buf bytes.Buffer
+	c.PrintHelp(&buf, &terminal.EscapeCodes{})
+	if !strings.HasPrefix(buf.String(), "sub <aid>\n") {
+		t.Errorf("Incorrect help:\n%s", buf.String())
+	}
+}
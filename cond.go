@@ -0,0 +1,375 @@
+// psmcli
+// Copyright (C) 2014 Procera Networks, Inc.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A condition is a comparison, or several joined with "and" and "or", e.g.
+// hostName ~ ^web and persistent == true. "and" binds harder than "or".
+type condition struct {
+	any [][]comparison // true if all comparisons of any group are
+}
+
+// A comparison compares two operands, or checks that one is "truthy" when
+// there is no operator.
+type comparison struct {
+	not         bool
+	left, right operand
+	op          string
+}
+
+// An operand is a literal value or a path into the item a condition is
+// evaluated for, like .hostName or attributes.note.
+type operand struct {
+	value  interface{}
+	path   string
+	isPath bool
+}
+
+var (
+	compareOps = []string{"==", "!=", "<=", ">=", "!~", "=", "<", ">", "~"}
+	itemExp    = regexp.MustCompile(`^\.?([A-Za-z_][A-Za-z0-9_-]*)?((?:\.[A-Za-z_][A-Za-z0-9_-]*|\[[0-9]+\])*)$`)
+)
+
+// parseCondition parses the condition. With item set, bare words on the
+// left side of comparisons are paths into the item; otherwise only words
+// starting with a dot are.
+func parseCondition(expr string, item bool) (condition, error) {
+	words, err := splitWords(expr)
+	if err != nil {
+		return condition{}, err
+	}
+	return buildCondition(words, func(word string, left bool) (operand, error) {
+		return parseOperand(word, item && left)
+	})
+}
+
+// buildCondition builds a condition of the words, using the operand
+// function to get the operand for each word that isn't an operator or
+// keyword.
+func buildCondition(words []string, operandFn func(word string, left bool) (operand, error)) (condition, error) {
+	var err error
+	words = splitOps(words)
+	if len(words) == 0 {
+		return condition{}, errors.New("missing condition")
+	}
+
+	var c condition
+	var group []comparison
+	for len(words) > 0 {
+		var cmp comparison
+		if words[0] == "not" {
+			cmp.not = true
+			words = words[1:]
+		}
+		if len(words) == 0 {
+			return condition{}, errors.New("missing value after not")
+		}
+		if cmp.left, err = operandFn(words[0], true); err != nil {
+			return condition{}, err
+		}
+		words = words[1:]
+
+		if len(words) > 0 && isCompareOp(words[0]) {
+			cmp.op = words[0]
+			if len(words) < 2 {
+				return condition{}, fmt.Errorf("missing value after %s", cmp.op)
+			}
+			if cmp.right, err = operandFn(words[1], false); err != nil {
+				return condition{}, err
+			}
+			if (cmp.op == "~" || cmp.op == "!~") && !cmp.right.isPath {
+				if _, err := regexp.Compile(plainString(cmp.right.value)); err != nil {
+					return condition{}, err
+				}
+			}
+			words = words[2:]
+		}
+		group = append(group, cmp)
+
+		if len(words) == 0 {
+			break
+		}
+		switch words[0] {
+		case "and":
+		case "or":
+			c.any = append(c.any, group)
+			group = nil
+		default:
+			return condition{}, fmt.Errorf("unexpected %s in condition; expected and, or or an operator", words[0])
+		}
+		if len(words) == 1 {
+			return condition{}, fmt.Errorf("missing condition after %s", words[0])
+		}
+		words = words[1:]
+	}
+
+	c.any = append(c.any, group)
+	return c, nil
+}
+
+// splitOps splits words like a==b, written without spaces around the
+// operator, into three words.
+func splitOps(words []string) []string {
+	var res []string
+	for _, w := range words {
+		// The value after an operator is taken as is, e.g. a=b in
+		// url == a=b
+		afterOp := len(res) > 0 && isCompareOp(res[len(res)-1])
+		if afterOp || strings.ContainsAny(w[:1], `"'{[(`) || isCompareOp(w) {
+			res = append(res, w)
+			continue
+		}
+		idx, op := -1, ""
+		for _, o := range compareOps {
+			if i := strings.Index(w, o); i > 0 && (idx < 0 || i < idx || i == idx && len(o) > len(op)) {
+				idx, op = i, o
+			}
+		}
+		if idx < 0 {
+			res = append(res, w)
+			continue
+		}
+		res = append(res, w[:idx], op)
+		if rest := w[idx+len(op):]; rest != "" {
+			res = append(res, rest)
+		}
+	}
+	return res
+}
+
+func isCompareOp(s string) bool {
+	for _, o := range compareOps {
+		if s == o {
+			return true
+		}
+	}
+	return false
+}
+
+func parseOperand(word string, item bool) (operand, error) {
+	switch word {
+	case "null", "true", "false":
+		item = false
+	}
+	if item || strings.HasPrefix(word, ".") {
+		if m := itemExp.FindStringSubmatch(word); m != nil {
+			path := m[2]
+			if m[1] != "" {
+				path = "." + m[1] + path
+			}
+			return operand{path: path, isPath: true}, nil
+		}
+	}
+
+	if indexUnquoted(word, '=') >= 0 {
+		// A value, not key=val attributes
+		return operand{value: unquote(word)}, nil
+	}
+	v, err := parseParam(word)
+	if err != nil {
+		return operand{}, err
+	}
+	switch t := v.(type) {
+	case typedString:
+		v = string(t)
+	case attributes:
+		v = map[string]interface{}(t)
+	}
+	return operand{value: v}, nil
+}
+
+// eval returns the value of the operand for the item. Paths that don't
+// exist in the item evaluate to null.
+func (o operand) eval(item interface{}) interface{} {
+	if !o.isPath {
+		return o.value
+	}
+	v, err := walkPath(item, o.path, "")
+	if err != nil {
+		return nil
+	}
+	return v
+}
+
+// eval returns whether the condition holds for the item.
+func (c condition) eval(item interface{}) bool {
+	for _, group := range c.any {
+		all := true
+		for _, cmp := range group {
+			if !cmp.eval(item) {
+				all = false
+				break
+			}
+		}
+		if all {
+			return true
+		}
+	}
+	return false
+}
+
+func (c comparison) eval(item interface{}) bool {
+	left := c.left.eval(item)
+	if c.op == "" {
+		return truthy(left) != c.not
+	}
+	return compareValues(left, c.op, c.right.eval(item)) != c.not
+}
+
+// compareValues compares the values, numerically if both are numbers or
+// numeric strings and as text otherwise. The ~ operator matches the left
+// value against the regular expression on the right.
+func compareValues(a interface{}, op string, b interface{}) bool {
+	switch op {
+	case "~", "!~":
+		exp, err := regexp.Compile(plainString(b))
+		if err != nil {
+			return false
+		}
+		s := ""
+		if a != nil {
+			s = plainString(a)
+		}
+		return exp.MatchString(s) == (op == "~")
+	}
+
+	cmp, numeric := compareNumbers(a, b)
+	switch {
+	case numeric:
+	case op == "=" || op == "==" || op == "!=":
+		if !reflect.DeepEqual(a, b) && plainString(a) != plainString(b) {
+			cmp = 1
+		}
+	default:
+		cmp = strings.Compare(plainString(a), plainString(b))
+	}
+
+	switch op {
+	case "=", "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+// compareNumbers compares the values numerically if both are numbers or
+// strings containing one. Integers are compared exactly, as OIDs are too
+// large for a float.
+func compareNumbers(a, b interface{}) (int, bool) {
+	if ai, ok := integer(a); ok {
+		if bi, ok := integer(b); ok {
+			return ai.Cmp(bi), true
+		}
+	}
+	af, aok := number(a)
+	bf, bok := number(b)
+	switch {
+	case !aok || !bok:
+		return 0, false
+	case af < bf:
+		return -1, true
+	case af > bf:
+		return 1, true
+	}
+	return 0, true
+}
+
+// integer returns the value as an integer, if it's one or a string
+// containing one.
+func integer(v interface{}) (*big.Int, bool) {
+	var s string
+	switch v := v.(type) {
+	case json.Number:
+		s = string(v)
+	case string:
+		s = v
+	case int:
+		return big.NewInt(int64(v)), true
+	default:
+		return nil, false
+	}
+	return new(big.Int).SetString(s, 10)
+}
+
+// number returns the value as a float, if it's a number or a string
+// containing one.
+func number(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// truthy returns false for null, false, zero and empty values, and true
+// for everything else.
+func truthy(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != ""
+	case json.Number:
+		f, err := v.Float64()
+		return err != nil || f != 0
+	case []interface{}:
+		return len(v) > 0
+	case map[string]interface{}:
+		return len(v) > 0
+	}
+	return true
+}
+
+// where returns the items of the list for which the condition holds.
+func where(v interface{}, expr string) (interface{}, error) {
+	c, err := parseCondition(expr, true)
+	if err != nil {
+		return nil, fmt.Errorf("where: %v", err)
+	}
+
+	list, ok := v.([]interface{})
+	if !ok {
+		if v == nil {
+			return v, nil
+		}
+		return nil, errors.New("where: the result is not a list")
+	}
+
+	res := []interface{}{}
+	for _, item := range list {
+		if c.eval(item) {
+			res = append(res, item)
+		}
+	}
+	return res, nil
+}
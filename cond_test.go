@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestWhere(t *testing.T) {
+	items := []interface{}{
+		map[string]interface{}{"name": "web1", "oid": json.Number("10"), "persistent": true, "attrs": map[string]interface{}{"note": "x"}},
+		map[string]interface{}{"name": "web2", "oid": json.Number("20"), "persistent": false},
+		map[string]interface{}{"name": "db1", "oid": json.Number("300"), "persistent": true},
+	}
+
+	testcases := []struct {
+		cond  string
+		names []string
+	}{
+		{"persistent == true", []string{"web1", "db1"}},
+		{"persistent", []string{"web1", "db1"}},
+		{"not persistent", []string{"web2"}},
+		{"persistent=false", []string{"web2"}},
+		{"oid > 15", []string{"web2", "db1"}},
+		{"oid<=20", []string{"web1", "web2"}},
+		{"name ~ ^web", []string{"web1", "web2"}},
+		{"name !~ ^web", []string{"db1"}},
+		{"name == 'db1' or oid == 10", []string{"web1", "db1"}},
+		{"name ~ web and persistent or oid > 100", []string{"web1", "db1"}},
+		{".attrs.note == x", []string{"web1"}},
+		{"attrs.note", []string{"web1"}},
+		{"missing == null", []string{"web1", "web2", "db1"}},
+		{`name == "web2"`, []string{"web2"}},
+	}
+
+	for _, tc := range testcases {
+		res, err := where(items, tc.cond)
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %v", tc.cond, err)
+			continue
+		}
+		var names []string
+		for _, item := range res.([]interface{}) {
+			names = append(names, item.(map[string]interface{})["name"].(string))
+		}
+		if !reflect.DeepEqual(names, tc.names) {
+			t.Errorf("Incorrect result for %q: %q != %q", tc.cond, names, tc.names)
+		}
+	}
+
+	for _, cond := range []string{"", "name ==", "name == a and", "name foo", "not", "name ~ ("} {
+		if _, err := where(items, cond); err == nil {
+			t.Errorf("Missing error for %q", cond)
+		}
+	}
+	if _, err := where(items[0], "name"); err == nil {
+		t.Error("Missing error for object")
+	}
+}
+
+func TestWhereExact(t *testing.T) {
+	items := []interface{}{
+		map[string]interface{}{"oid": json.Number("288230376151715606"), "url": "a=b"},
+		map[string]interface{}{"oid": json.Number("288230376151715607"), "url": "a"},
+	}
+
+	testcases := []struct {
+		cond string
+		oids []string
+	}{
+		// Beyond the precision of a float
+		{"oid == 288230376151715606", []string{"288230376151715606"}},
+		{"oid > 288230376151715606", []string{"288230376151715607"}},
+		// Only the operator is split off
+		{"url == a=b", []string{"288230376151715606"}},
+		{"url==a=b", []string{"288230376151715606"}},
+	}
+
+	for _, tc := range testcases {
+		res, err := where(items, tc.cond)
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %v", tc.cond, err)
+			continue
+		}
+		var oids []string
+		for _, item := range res.([]interface{}) {
+			oids = append(oids, plainString(item.(map[string]interface{})["oid"]))
+		}
+		if !reflect.DeepEqual(oids, tc.oids) {
+			t.Errorf("Incorrect result for %q: %q != %q", tc.cond, oids, tc.oids)
+		}
+	}
+}
+
+func TestCompareValues(t *testing.T) {
+	testcases := []struct {
+		a, b interface{}
+		op   string
+		res  bool
+	}{
+		{json.Number("10"), "10.0", "==", true},
+		{json.Number("9"), json.Number("10"), "<", true},
+		{"9", "10", "<", true},
+		{"b", "a", ">", true},
+		{true, "true", "==", true},
+		{nil, nil, "==", true},
+		{nil, "", "!=", true},
+		{map[string]interface{}{"a": "b"}, map[string]interface{}{"a": "b"}, "==", true},
+		{"abc", "b", "~", true},
+		{json.Number("288230376151715606"), json.Number("288230376151715607"), "<", true},
+		{json.Number("288230376151715606"), "288230376151715607", "==", false},
+		{json.Number("1.5"), "2", "<", true},
+	}
+
+	for _, tc := range testcases {
+		if res := compareValues(tc.a, tc.op, tc.b); res != tc.res {
+			t.Errorf("Incorrect comparison %#v %s %#v: %v", tc.a, tc.op, tc.b, res)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+// psmcli
+// Copyright (C) 2014 Procera Networks, Inc.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+type copyOptions struct {
+	typ      string
+	selector string
+	to       string
+	user     string
+	policy   string
+	dryRun   bool
+}
+
+// parseCopy parses the arguments to the copy command:
+//
+//	copy [--policy=skip|overwrite|fail] [--dry-run] [--user=name] type selector --to profile
+//
+// The selector is an application ID, "all", or "where" and a condition on
+// the objects.
+func parseCopy(args string) (copyOptions, error) {
+	opts := copyOptions{policy: policyFail}
+	words, err := splitWords(args)
+	if err != nil {
+		return opts, err
+	}
+
+	var rest []string
+	for i := 0; i < len(words); i++ {
+		w := words[i]
+		switch {
+		case w == "--to" && i+1 < len(words):
+			i++
+			opts.to = unquote(words[i])
+		case strings.HasPrefix(w, "--to="):
+			opts.to = unquote(w[len("--to="):])
+		case w == "--user" && i+1 < len(words):
+			i++
+			opts.user = unquote(words[i])
+		case strings.HasPrefix(w, "--user="):
+			opts.user = unquote(w[len("--user="):])
+		case strings.HasPrefix(w, "--policy="):
+			opts.policy = unquote(w[len("--policy="):])
+			switch opts.policy {
+			case policySkip, policyOverwrite, policyFail:
+			default:
+				return opts, fmt.Errorf("unknown policy %q; use skip, overwrite or fail", opts.policy)
+			}
+		case w == "--dry-run":
+			opts.dryRun = true
+		case strings.HasPrefix(w, "--") && len(rest) < 2:
+			return opts, fmt.Errorf("unknown option %s", w)
+		default:
+			rest = append(rest, w)
+		}
+	}
+
+	if len(rest) < 2 || opts.to == "" {
+		return opts, fmt.Errorf("Usage: copy [--policy=skip|overwrite|fail] [--dry-run] [--user=name] type <aid | all | where condition> --to profile")
+	}
+	opts.typ = unquote(rest[0])
+	if len(rest) == 2 {
+		opts.selector = unquote(rest[1])
+	} else {
+		opts.selector = strings.Join(rest[1:], " ")
+	}
+	return opts, nil
+}
+
+// selectObjects returns the objects of the type matching the selector; an
+// application ID, "all", or "where" and a condition.
+func (s *session) selectObjects(typ, selector string) ([]typedObject, bool, error) {
+	match := func(obj map[string]interface{}) bool {
+		return objectAid(typ, obj) == selector
+	}
+	switch {
+	case selector == "all":
+		match = func(map[string]interface{}) bool { return true }
+	case strings.HasPrefix(selector, "where "):
+		c, err := parseCondition(strings.TrimPrefix(selector, "where "), true)
+		if err != nil {
+			fmt.Fprintln(s.out, "where:", err)
+			return nil, false, nil
+		}
+		match = func(obj map[string]interface{}) bool { return c.eval(obj) }
+	}
+
+	var objs []typedObject
+	ok, err := s.listObjects(typ, defaultPageSize, func(obj map[string]interface{}) error {
+		if match(obj) {
+			objs = append(objs, typedObject{typ, obj})
+		}
+		return nil
+	})
+	return objs, ok, err
+}
+
+// findObjects returns the objects with the OIDs, looking through all
+// object types.
+func (s *session) findObjects(oids map[string]bool) (map[string]typedObject, bool, error) {
+	found := make(map[string]typedObject)
+	for _, typ := range objectTypes(s.services) {
+		if len(found) == len(oids) {
+			break
+		}
+		ok, err := s.listObjects(typ, defaultPageSize, func(obj map[string]interface{}) error {
+			if oid, ok := obj["oid"]; ok && oid != nil && oids[plainString(oid)] {
+				found[plainString(oid)] = typedObject{typ, obj}
+			}
+			return nil
+		})
+		if err != nil || !ok {
+			return nil, false, err
+		}
+	}
+	return found, true, nil
+}
+
+// copyObjects copies the selected objects to the target node. Parents of
+// the objects that aren't selected themselves must exist on the target,
+// with the same application ID. Errors on the target connection are
+// printed; only those on the session's own connection are returned.
+func (s *session) copyObjects(target *session, opts copyOptions) (bool, error) {
+	objs, ok, err := s.selectObjects(opts.typ, opts.selector)
+	if err != nil || !ok {
+		return false, err
+	}
+	if len(objs) == 0 {
+		fmt.Fprintf(s.out, "No %s objects match %s\n", opts.typ, opts.selector)
+		return false, nil
+	}
+
+	// Parents that aren't copied are looked up by application ID on the
+	// target
+
+	selected := make(map[string]bool)
+	for _, o := range objs {
+		if oid, ok := o.Object["oid"]; ok && oid != nil {
+			selected[plainString(oid)] = true
+		}
+	}
+	others := make(map[string]bool)
+	for _, o := range objs {
+		if p, ok := o.Object["parentOid"]; ok && p != nil && !selected[plainString(p)] {
+			others[plainString(p)] = true
+		}
+	}
+	parents := make(map[string]interface{})
+	if len(others) > 0 {
+		found, ok, err := s.findObjects(others)
+		if err != nil || !ok {
+			return false, err
+		}
+		var types []string
+		for _, p := range found {
+			types = append(types, p.Type)
+		}
+		onTarget, ok, err := target.existingObjects(types)
+		if err != nil {
+			fmt.Fprintf(s.out, "%s: %v\n", opts.to, err)
+			return false, nil
+		}
+		if !ok {
+			return false, nil
+		}
+		for oid, p := range found {
+			aid := objectAid(p.Type, p.Object)
+			if toid, ok := onTarget[p.Type][aid]; ok {
+				parents[oid] = toid
+			} else {
+				fmt.Fprintf(s.out, "Parent %s %s isn't on %s\n", p.Type, aid, opts.to)
+			}
+		}
+	}
+
+	existing, ok, err := target.existingObjects([]string{opts.typ})
+	if err != nil {
+		fmt.Fprintf(s.out, "%s: %v\n", opts.to, err)
+		return false, nil
+	}
+	if !ok {
+		return false, nil
+	}
+
+	fmt.Fprintf(s.out, "Copying to %s:\n", opts.to)
+	conflicts := preflight(s.out, objs, existing, opts.policy)
+	if conflicts > 0 && opts.policy == policyFail {
+		fmt.Fprintf(s.out, "%d objects already exist; nothing copied (use --policy=skip or --policy=overwrite)\n", conflicts)
+		return false, nil
+	}
+	if opts.dryRun {
+		return true, nil
+	}
+
+	res, err := target.transfer(objs, existing, parents, opts.policy, true)
+	if err != nil {
+		fmt.Fprintf(s.out, "%s: %v\n", opts.to, err)
+		return false, nil
+	}
+	fmt.Fprintln(s.out, res)
+	return res.failed == 0, nil
+}
+
+// copyCmd runs the copy command, connecting to the target node for the
+// duration of the copy. Like other commands, the error returned is a
+// failure of the session's connection.
+func (s *session) copyCmd(args string) (bool, error) {
+	opts, err := parseCopy(args)
+	if err != nil {
+		fmt.Fprintln(s.out, err)
+		return false, nil
+	}
+
+	target, err := connectBatch(opts.to, opts.user, s.out, s.verbose)
+	if err != nil {
+		fmt.Fprintf(s.out, "%s: %v\n", opts.to, err)
+		return false, nil
+	}
+	defer target.conn.conn.Close()
+
+	return s.copyObjects(target, opts)
+}
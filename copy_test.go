@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseCopy(t *testing.T) {
+	testcases := []struct {
+		args string
+		opts copyOptions
+		err  bool
+	}{
+		{" subscriber 1234 --to lab", copyOptions{typ: "subscriber", selector: "1234", to: "lab", policy: policyFail}, false},
+		{" --dry-run --policy=skip subscriber all --to=lab", copyOptions{typ: "subscriber", selector: "all", to: "lab", policy: policySkip, dryRun: true}, false},
+		{" subscriber where hostName ~ ^web --to lab", copyOptions{typ: "subscriber", selector: "where hostName ~ ^web", to: "lab", policy: policyFail}, false},
+		{" subscriber where slot > 5 --user admin --to lab", copyOptions{typ: "subscriber", selector: "where slot > 5", to: "lab", user: "admin", policy: policyFail}, false},
+		{" --user=admin subscriber all --to lab", copyOptions{typ: "subscriber", selector: "all", to: "lab", user: "admin", policy: policyFail}, false},
+		{" subscriber \"a b\" --to lab", copyOptions{typ: "subscriber", selector: "a b", to: "lab", policy: policyFail}, false},
+		{" subscriber 1234", copyOptions{}, true},
+		{" subscriber --to lab", copyOptions{}, true},
+		{" --policy=merge subscriber 1234 --to lab", copyOptions{}, true},
+		{" --force subscriber 1234 --to lab", copyOptions{}, true},
+	}
+
+	for _, tc := range testcases {
+		opts, err := parseCopy(tc.args)
+		if tc.err {
+			if err == nil {
+				t.Errorf("Unexpected nil error for %q", tc.args)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %v", tc.args, err)
+		} else if opts != tc.opts {
+			t.Errorf("Incorrect options for %q: %+v != expected %+v", tc.args, opts, tc.opts)
+		}
+	}
+}
+
+func TestCopyObjects(t *testing.T) {
+	testcases := []struct {
+		opts  copyOptions
+		ok    bool
+		calls []string
+	}{
+		// Subscriber b's parent isn't on the target, so it fails
+		{copyOptions{typ: "subscriber", selector: "where hostName ~ ^web", to: "lab", policy: policyFail}, false, []string{
+			"object.create [subscriber map[hostName:web1 parentOid:500 subscriberId:a]]",
+		}},
+		{copyOptions{typ: "subscriber", selector: "a", to: "lab", policy: policyFail, dryRun: true}, true, nil},
+		{copyOptions{typ: "subscriber", selector: "c", to: "lab", policy: policyFail}, false, nil},
+		{copyOptions{typ: "subscriber", selector: "c", to: "lab", policy: policyOverwrite}, true, []string{
+			"object.updateByAid [subscriber c map[hostName:db1]]",
+		}},
+		{copyOptions{typ: "group", selector: "all", to: "lab", policy: policySkip}, true, []string{
+			"object.create [group map[aid:g2]]",
+		}},
+		{copyOptions{typ: "subscriber", selector: "x", to: "lab", policy: policySkip}, false, nil},
+	}
+
+	for _, tc := range testcases {
+		src := &fakePSM{hostname: "psm1", objects: map[string][]map[string]interface{}{
+			"group": {
+				{"oid": json.Number("10"), "aid": "g1"},
+				{"oid": json.Number("11"), "aid": "g2"},
+			},
+			"subscriber": {
+				{"oid": json.Number("1"), "subscriberId": "a", "parentOid": json.Number("10"), "hostName": "web1", "creationTime": "x"},
+				{"oid": json.Number("2"), "subscriberId": "b", "parentOid": json.Number("11"), "hostName": "web2"},
+				{"oid": json.Number("3"), "subscriberId": "c", "hostName": "db1"},
+			},
+		}}
+		dst := &fakePSM{hostname: "psm2", nextOid: 600, objects: map[string][]map[string]interface{}{
+			"group":      {{"oid": json.Number("500"), "aid": "g1"}},
+			"subscriber": {{"oid": json.Number("501"), "subscriberId": "c"}},
+		}}
+		s, out := testSession(src.handle)
+		s.services = map[string]smdService{
+			"group.list":      {Parameters: []smdParameter{{Name: "limit"}, {Name: "offset"}}},
+			"subscriber.list": {Parameters: []smdParameter{{Name: "limit"}, {Name: "offset"}}},
+		}
+		target, _ := testSession(dst.handle)
+
+		ok, err := s.copyObjects(target, tc.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != tc.ok {
+			t.Errorf("Incorrect result for %s %s: %v != expected %v\n%s", tc.opts.typ, tc.opts.selector, ok, tc.ok, out)
+		}
+		if !reflect.DeepEqual(dst.calls, tc.calls) {
+			t.Errorf("Incorrect calls for %s %s:\n%q\n!= expected\n%q", tc.opts.typ, tc.opts.selector, dst.calls, tc.calls)
+		}
+		if len(src.calls) != 0 {
+			t.Errorf("Unexpected calls on the source node: %q", src.calls)
+		}
+	}
+}
+
+func TestCopyObjectsReport(t *testing.T) {
+	src := &fakePSM{objects: map[string][]map[string]interface{}{
+		"subscriber": {{"oid": json.Number("1"), "subscriberId": "a", "parentOid": json.Number("10")}},
+		"group":      {{"oid": json.Number("10"), "aid": "g1"}},
+	}}
+	s, out := testSession(src.handle)
+	s.services = map[string]smdService{
+		"group.list":      {Parameters: []smdParameter{{Name: "limit"}, {Name: "offset"}}},
+		"subscriber.list": {Parameters: []smdParameter{{Name: "limit"}, {Name: "offset"}}},
+	}
+	target, _ := testSession((&fakePSM{}).handle)
+	target.out = out
+
+	s.copyObjects(target, copyOptions{typ: "subscriber", selector: "a", to: "lab", policy: policyFail})
+	for _, exp := range []string{"Parent group g1 isn't on lab", "  subscriber: 1 objects, 1 new", "subscriber a: failed, parent 10"} {
+		if !strings.Contains(out.String(), exp) {
+			t.Errorf("Missing %q in output:\n%s", exp, out)
+		}
+	}
+}
+
+func TestCopyCommand(t *testing.T) {
+	// Comparisons in the selector aren't taken as redirections
+	s, out := testSession((&fakePSM{}).handle)
+	if ok, err := s.execute("copy subscriber where slot > 5 || system hostname"); !ok || err != nil {
+		t.Errorf("Unexpected failure: %v %v\n%s", ok, err, out)
+	}
+	if !strings.HasPrefix(out.String(), "Usage: copy") {
+		t.Errorf("Missing usage in output:\n%s", out)
+	}
+}
+
+func TestCopyObjectsConnectionErrors(t *testing.T) {
+	opts := copyOptions{typ: "subscriber", selector: "all", to: "lab", policy: policyFail}
+	objects := map[string][]map[string]interface{}{
+		"subscriber": {{"oid": json.Number("1"), "subscriberId": "a"}},
+	}
+
+	// A failure of the target connection is reported
+	s, out := testSession((&fakePSM{objects: objects}).handle)
+	target, _ := testSession((&fakePSM{}).handle)
+	target.conn.conn.Close()
+	if ok, err := s.copyObjects(target, opts); ok || err != nil {
+		t.Errorf("Unexpected result with a closed target connection: %v %v", ok, err)
+	}
+	if !strings.HasPrefix(out.String(), "lab: ") {
+		t.Errorf("Missing target error in output:\n%s", out)
+	}
+
+	// A failure of the session's own connection is returned
+	s, _ = testSession((&fakePSM{objects: objects}).handle)
+	target, _ = testSession((&fakePSM{}).handle)
+	s.conn.conn.Close()
+	if _, err := s.copyObjects(target, opts); err == nil {
+		t.Errorf("Unexpected nil error with a closed connection")
+	}
+}
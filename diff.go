@@ -0,0 +1,317 @@
+// psmcli
+// Copyright (C) 2014 Procera Networks, Inc.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The kinds of object differences.
+const (
+	changeAdded   = "added"
+	changeRemoved = "removed"
+	changeChanged = "changed"
+)
+
+// objectDiff is an object that differs between two sets of objects.
+type objectDiff struct {
+	Type       string                 `json:"type"`
+	Aid        string                 `json:"aid"`
+	Change     string                 `json:"change"`
+	Object     map[string]interface{} `json:"object,omitempty"`
+	Attributes []attributeDiff        `json:"attributes,omitempty"`
+}
+
+// attributeDiff is an attribute of a changed object. Old or New is absent
+// if the attribute is null or missing on that side.
+type attributeDiff struct {
+	Name string      `json:"name"`
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
+
+// diffResult is the difference between two snapshots, as given by -json.
+type diffResult struct {
+	From    snapshotHeader `json:"from"`
+	To      snapshotHeader `json:"to"`
+	Added   int            `json:"added"`
+	Removed int            `json:"removed"`
+	Changed int            `json:"changed"`
+	Objects []objectDiff   `json:"objects"`
+}
+
+// comparableObjects returns the objects by type and application ID,
+// without the attributes managed by PSM, and with parentOid replaced by
+// the type and application ID of the parent when it's among the objects,
+// so that objects from different nodes can be compared. Objects without
+// an application ID are left out and counted.
+func comparableObjects(objs []typedObject) (map[string]map[string]interface{}, int) {
+	parents := make(map[string]string)
+	for _, o := range objs {
+		if oid, ok := o.Object["oid"]; ok && oid != nil {
+			if aid := objectAid(o.Type, o.Object); aid != "" {
+				parents[plainString(oid)] = o.Type + " " + aid
+			}
+		}
+	}
+
+	res := make(map[string]map[string]interface{})
+	skipped := 0
+	for _, o := range objs {
+		aid := objectAid(o.Type, o.Object)
+		if aid == "" {
+			skipped++
+			continue
+		}
+		obj := make(map[string]interface{}, len(o.Object))
+		for k, v := range o.Object {
+			obj[k] = v
+		}
+		for _, f := range serverFields {
+			delete(obj, f)
+		}
+		if p, ok := obj["parentOid"]; ok && p != nil {
+			if ref, ok := parents[plainString(p)]; ok {
+				obj["parentOid"] = ref
+			}
+		}
+		res[o.Type+" "+aid] = obj
+	}
+	return res, skipped
+}
+
+// diffObjects returns the objects added, removed or changed from a to b,
+// ordered by type and application ID.
+func diffObjects(a, b []typedObject) ([]objectDiff, int) {
+	ca, skippedA := comparableObjects(a)
+	cb, skippedB := comparableObjects(b)
+
+	keys := make(map[string]bool)
+	for k := range ca {
+		keys[k] = true
+	}
+	for k := range cb {
+		keys[k] = true
+	}
+	var sorted []string
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	var diffs []objectDiff
+	for _, k := range sorted {
+		parts := strings.SplitN(k, " ", 2)
+		d := objectDiff{Type: parts[0], Aid: parts[1]}
+		oa, inA := ca[k]
+		ob, inB := cb[k]
+		switch {
+		case !inA:
+			d.Change, d.Object = changeAdded, ob
+		case !inB:
+			d.Change, d.Object = changeRemoved, oa
+		default:
+			d.Attributes = diffAttributes(oa, ob, "", nil)
+			if len(d.Attributes) == 0 {
+				continue
+			}
+			d.Change = changeChanged
+		}
+		diffs = append(diffs, d)
+	}
+	return diffs, skippedA + skippedB
+}
+
+// diffAttributes appends the differing attributes of the objects, with
+// nested objects compared attribute by attribute under dotted names.
+// Missing attributes are the same as null ones.
+func diffAttributes(a, b map[string]interface{}, prefix string, res []attributeDiff) []attributeDiff {
+	names := make(map[string]bool)
+	for k := range a {
+		names[k] = true
+	}
+	for k := range b {
+		names[k] = true
+	}
+	var sorted []string
+	for k := range names {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	for _, k := range sorted {
+		va, vb := a[k], b[k]
+		ma, okA := va.(map[string]interface{})
+		mb, okB := vb.(map[string]interface{})
+		if okA && okB {
+			res = diffAttributes(ma, mb, prefix+k+".", res)
+			continue
+		}
+		ja, _ := json.Marshal(va)
+		jb, _ := json.Marshal(vb)
+		if !bytes.Equal(ja, jb) {
+			res = append(res, attributeDiff{Name: prefix + k, Old: va, New: vb})
+		}
+	}
+	return res
+}
+
+// newDiffResult returns the differences between the snapshots.
+func newDiffResult(from, to snapshotHeader, a, b []typedObject) (diffResult, int) {
+	diffs, skipped := diffObjects(a, b)
+	res := diffResult{From: from, To: to, Objects: diffs}
+	if res.Objects == nil {
+		res.Objects = []objectDiff{}
+	}
+	for _, d := range diffs {
+		switch d.Change {
+		case changeAdded:
+			res.Added++
+		case changeRemoved:
+			res.Removed++
+		default:
+			res.Changed++
+		}
+	}
+	return res, skipped
+}
+
+// writeText writes the differences as text; + for added objects, - for
+// removed and ~ for changed, followed by the changed attributes.
+func (r diffResult) writeText(w io.Writer) {
+	fmt.Fprintln(w, "---", r.From)
+	fmt.Fprintln(w, "+++", r.To)
+	for _, d := range r.Objects {
+		switch d.Change {
+		case changeAdded:
+			fmt.Fprintf(w, "+ %s %s\n", d.Type, d.Aid)
+		case changeRemoved:
+			fmt.Fprintf(w, "- %s %s\n", d.Type, d.Aid)
+		default:
+			fmt.Fprintf(w, "~ %s %s\n", d.Type, d.Aid)
+			for _, a := range d.Attributes {
+				fmt.Fprintf(w, "    %s: %s -> %s\n", a.Name, diffValue(a.Old), diffValue(a.New))
+			}
+		}
+	}
+	fmt.Fprintf(w, "%d added, %d removed, %d changed\n", r.Added, r.Removed, r.Changed)
+}
+
+// diffValue returns the attribute value in JSON, or (none) if null or
+// missing.
+func diffValue(v interface{}) string {
+	if v == nil {
+		return "(none)"
+	}
+	bs, _ := json.Marshal(v)
+	return string(bs)
+}
+
+// isSnapshotFile returns true if the source is a file, or standard input,
+// rather than a node.
+func isSnapshotFile(src string) bool {
+	_, err := os.Stat(src)
+	return err == nil || src == "-"
+}
+
+// loadObjects returns the objects of a snapshot file, or of the live node
+// if src isn't a file. Types limits the types read from a live node.
+func loadObjects(src string, types []string, user string, verbose bool) (snapshotHeader, []typedObject, error) {
+	if isSnapshotFile(src) {
+		return readSnapshotFile(src)
+	}
+
+	s, err := connectBatch(src, user, os.Stderr, verbose)
+	if err != nil {
+		return snapshotHeader{}, nil, err
+	}
+	var buf bytes.Buffer
+	ok, err := s.snapshot(&buf, types, time.Now())
+	if err != nil {
+		return snapshotHeader{}, nil, err
+	}
+	if !ok {
+		return snapshotHeader{}, nil, fmt.Errorf("%s: listing objects failed", src)
+	}
+	return readSnapshot(&buf)
+}
+
+// diffMain runs "psmcli diff", comparing two snapshots or nodes. The exit
+// code is 0 if they're the same, 1 if they differ and 2 on errors.
+func diffMain(args []string, user string, verbose bool) int {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "Print the differences as JSON")
+	types := fs.String("types", "", "Object types to compare, comma separated (default all)")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "Usage: psmcli [options] diff [-json] [-types=a,b] <snapshot | host:port | profile> <snapshot | host:port | profile>")
+		return 2
+	}
+
+	var typeList []string
+	if *types != "" {
+		typeList = strings.Split(*types, ",")
+	}
+
+	// A snapshot is read first, so that a node compared to it is read
+	// for the same types
+
+	order := []int{0, 1}
+	if !isSnapshotFile(fs.Arg(0)) && isSnapshotFile(fs.Arg(1)) {
+		order = []int{1, 0}
+	}
+	var hdrs [2]snapshotHeader
+	var objs [2][]typedObject
+	for _, i := range order {
+		var err error
+		hdrs[i], objs[i], err = loadObjects(fs.Arg(i), typeList, user, verbose)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		if *types != "" {
+			objs[i] = filterTypes(objs[i], typeList)
+		} else if typeList == nil {
+			typeList = hdrs[i].Types
+		}
+	}
+
+	res, skipped := newDiffResult(hdrs[0], hdrs[1], objs[0], objs[1])
+	if skipped > 0 {
+		fmt.Fprintf(os.Stderr, "%d objects without application ID not compared\n", skipped)
+	}
+	if *asJSON {
+		bs, _ := json.MarshalIndent(res, "", "    ")
+		fmt.Printf("%s\n", bs)
+	} else {
+		res.writeText(os.Stdout)
+	}
+
+	if len(res.Objects) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// filterTypes returns the objects of the types.
+func filterTypes(objs []typedObject, types []string) []typedObject {
+	var res []typedObject
+	for _, o := range objs {
+		for _, t := range types {
+			if o.Type == t {
+				res = append(res, o)
+				break
+			}
+		}
+	}
+	return res
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestDiffObjects(t *testing.T) {
+	// The same group and subscribers on two nodes, with different OIDs
+	a := []typedObject{
+		{"group", map[string]interface{}{"oid": json.Number("10"), "aid": "g1"}},
+		{"subscriber", map[string]interface{}{"oid": json.Number("1"), "subscriberId": "a", "parentOid": json.Number("10"), "hostName": "web1", "updateTime": "x"}},
+		{"subscriber", map[string]interface{}{"oid": json.Number("2"), "subscriberId": "b", "persistent": true, "opts": map[string]interface{}{"x": json.Number("1"), "y": "z"}}},
+		{"subscriber", map[string]interface{}{"oid": json.Number("3"), "subscriberId": "c"}},
+		{"subscriber", map[string]interface{}{"oid": json.Number("4")}},
+	}
+	b := []typedObject{
+		{"group", map[string]interface{}{"oid": json.Number("20"), "aid": "g1"}},
+		{"subscriber", map[string]interface{}{"oid": json.Number("5"), "subscriberId": "a", "parentOid": json.Number("20"), "hostName": "web1", "updateTime": "y", "expire": nil}},
+		{"subscriber", map[string]interface{}{"oid": json.Number("6"), "subscriberId": "b", "opts": map[string]interface{}{"x": json.Number("2"), "y": "z"}}},
+		{"subscriber", map[string]interface{}{"oid": json.Number("7"), "subscriberId": "d", "hostName": "web4"}},
+	}
+
+	res, skipped := newDiffResult(snapshotHeader{Hostname: "psm1"}, snapshotHeader{Hostname: "psm2"}, a, b)
+	if skipped != 1 {
+		t.Errorf("Incorrect number of skipped objects %d != expected 1", skipped)
+	}
+
+	var buf bytes.Buffer
+	res.writeText(&buf)
+	exp := `--- psm1 (PSM )
++++ psm2 (PSM )
+~ subscriber b
+    opts.x: 1 -> 2
+    persistent: true -> (none)
+- subscriber c
++ subscriber d
+1 added, 1 removed, 1 changed
+`
+	if buf.String() != exp {
+		t.Errorf("Incorrect text diff:\n%s\n!= expected\n%s", buf.String(), exp)
+	}
+
+	bs, err := json.Marshal(res.Objects)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expJSON := `[{"type":"subscriber","aid":"b","change":"changed","attributes":[{"name":"opts.x","old":1,"new":2},{"name":"persistent","old":true}]},` +
+		`{"type":"subscriber","aid":"c","change":"removed","object":{"subscriberId":"c"}},` +
+		`{"type":"subscriber","aid":"d","change":"added","object":{"hostName":"web4","subscriberId":"d"}}]`
+	if string(bs) != expJSON {
+		t.Errorf("Incorrect JSON diff:\n%s\n!= expected\n%s", bs, expJSON)
+	}
+
+	res, _ = newDiffResult(snapshotHeader{}, snapshotHeader{}, b, b)
+	if len(res.Objects) != 0 || res.Added+res.Removed+res.Changed != 0 {
+		t.Errorf("Unexpected differences of identical objects: %+v", res)
+	}
+}
@@ -0,0 +1,347 @@
+// psmcli
+// Copyright (C) 2014 Procera Networks, Inc.
+
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// csvSample is the number of objects looked at for the columns of a CSV
+// export when no fields are given.
+const csvSample = 100
+
+type exportOptions struct {
+	typ      string
+	file     string
+	format   string
+	fields   []string
+	pageSize int
+}
+
+// parseExport parses the arguments to the export command:
+//
+//	export [--fields=a,b.c] [--format=csv|jsonl|json] [--page=N] type file
+func parseExport(args string) (exportOptions, error) {
+	opts := exportOptions{pageSize: defaultPageSize}
+	words, err := splitWords(args)
+	if err != nil {
+		return opts, err
+	}
+
+	var rest []string
+	for _, w := range words {
+		if !strings.HasPrefix(w, "--") {
+			rest = append(rest, unquote(w))
+			continue
+		}
+		name, val := w[2:], ""
+		if eq := strings.IndexByte(name, '='); eq >= 0 {
+			name, val = name[:eq], unquote(name[eq+1:])
+		}
+		switch name {
+		case "fields":
+			for _, f := range strings.Split(val, ",") {
+				if f = strings.TrimSpace(f); f != "" {
+					opts.fields = append(opts.fields, f)
+				}
+			}
+		case "format":
+			if !knownFormat(val) {
+				return opts, fmt.Errorf("unknown format %q; use csv, jsonl or json", val)
+			}
+			opts.format = val
+		case "page":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 1 {
+				return opts, fmt.Errorf("page must be a positive number")
+			}
+			opts.pageSize = n
+		default:
+			return opts, fmt.Errorf("unknown option --%s", name)
+		}
+	}
+
+	if len(rest) != 2 {
+		return opts, fmt.Errorf("Usage: export [--fields=a,b.c] [--format=csv|jsonl|json] [--page=N] type file")
+	}
+	opts.typ, opts.file = rest[0], rest[1]
+	if opts.format == "" {
+		opts.format = fileFormat(opts.file)
+	}
+	return opts, nil
+}
+
+// exportFile runs the export command, writing all objects of the type to
+// the file as they are listed.
+func (s *session) exportFile(args string) (bool, error) {
+	opts, err := parseExport(args)
+	if err != nil {
+		fmt.Fprintln(s.out, err)
+		return false, nil
+	}
+
+	// The export is written to a temporary file, replacing the file only
+	// when complete, so a failed export doesn't destroy a previous one
+	fd, err := ioutil.TempFile(filepath.Dir(opts.file), "."+filepath.Base(opts.file)+".")
+	if err != nil {
+		fmt.Fprintln(s.out, err)
+		return false, nil
+	}
+	defer os.Remove(fd.Name())
+	defer fd.Close()
+
+	bw := bufio.NewWriter(fd)
+	w := newObjectWriter(bw, opts.format, opts.fields)
+	p := newProgress(s.out, "exported", 0)
+	ok, err := s.listObjects(opts.typ, opts.pageSize, func(obj map[string]interface{}) error {
+		p.done(true)
+		return w.write(obj)
+	})
+	if err != nil {
+		return false, err
+	}
+	if err := w.close(); err != nil && ok {
+		fmt.Fprintln(s.out, err)
+		ok = false
+	}
+	if err := bw.Flush(); err != nil && ok {
+		fmt.Fprintln(s.out, err)
+		ok = false
+	}
+	p.finish()
+	if !ok {
+		return false, nil
+	}
+
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(opts.file); err == nil {
+		mode = fi.Mode()
+	}
+	if err := fd.Chmod(mode); err != nil {
+		fmt.Fprintln(s.out, err)
+		return false, nil
+	}
+	if err := fd.Close(); err != nil {
+		fmt.Fprintln(s.out, err)
+		return false, nil
+	}
+	if err := os.Rename(fd.Name(), opts.file); err != nil {
+		fmt.Fprintln(s.out, err)
+		return false, nil
+	}
+	return true, nil
+}
+
+// objectWriter writes objects in one of the import formats.
+type objectWriter interface {
+	write(obj map[string]interface{}) error
+	close() error
+}
+
+// newObjectWriter returns a writer of the format, writing only the given
+// fields if any.
+func newObjectWriter(w io.Writer, format string, fields []string) objectWriter {
+	switch format {
+	case formatJSONL:
+		return &jsonlWriter{w: w, fields: fields}
+	case formatJSON:
+		return &jsonWriter{jsonlWriter: jsonlWriter{w: w, fields: fields}}
+	}
+	return &csvWriter{cw: csv.NewWriter(w), fields: fields}
+}
+
+// jsonlWriter writes one JSON object per line.
+type jsonlWriter struct {
+	w      io.Writer
+	fields []string
+}
+
+func (w *jsonlWriter) write(obj map[string]interface{}) error {
+	if w.fields != nil {
+		obj = selectFields(obj, w.fields)
+	}
+	bs, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	_, err = w.w.Write(append(bs, '\n'))
+	return err
+}
+
+func (w *jsonlWriter) close() error {
+	return nil
+}
+
+// jsonWriter writes a JSON array of objects, one per line.
+type jsonWriter struct {
+	jsonlWriter
+	n int
+}
+
+func (w *jsonWriter) write(obj map[string]interface{}) error {
+	sep := ",\n"
+	if w.n == 0 {
+		sep = "[\n"
+	}
+	w.n++
+	if _, err := io.WriteString(w.w, sep); err != nil {
+		return err
+	}
+	if w.fields != nil {
+		obj = selectFields(obj, w.fields)
+	}
+	bs, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	_, err = w.w.Write(bs)
+	return err
+}
+
+func (w *jsonWriter) close() error {
+	end := "\n]\n"
+	if w.n == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(w.w, end)
+	return err
+}
+
+// csvWriter writes a header row and a row per object, with nested
+// attributes in dotted columns as read by import. Without given fields the
+// columns are those of the first objects, and a later object with an
+// attribute outside them is an error.
+type csvWriter struct {
+	cw      *csv.Writer
+	fields  []string
+	pending []map[string]string
+	header  bool
+	columns map[string]bool // the sampled columns, without given fields
+}
+
+func (w *csvWriter) write(obj map[string]interface{}) error {
+	flat := make(map[string]string)
+	flatten(obj, "", flat)
+	if w.fields == nil {
+		w.pending = append(w.pending, flat)
+		if len(w.pending) < csvSample {
+			return nil
+		}
+		return w.flush()
+	}
+	if w.columns != nil {
+		for k := range flat {
+			if !w.columns[k] {
+				return fmt.Errorf("attribute %s isn't among the columns of the first %d objects; use --fields", k, csvSample)
+			}
+		}
+	}
+	return w.row(flat)
+}
+
+// flush writes the header and the objects kept to decide it.
+func (w *csvWriter) flush() error {
+	if w.fields == nil && len(w.pending) > 0 {
+		w.columns = make(map[string]bool)
+		w.fields = []string{}
+		for _, flat := range w.pending {
+			for k := range flat {
+				if !w.columns[k] {
+					w.columns[k] = true
+					w.fields = append(w.fields, k)
+				}
+			}
+		}
+		sort.Strings(w.fields)
+	}
+	for _, flat := range w.pending {
+		if err := w.row(flat); err != nil {
+			return err
+		}
+	}
+	w.pending = nil
+	return nil
+}
+
+func (w *csvWriter) row(flat map[string]string) error {
+	if !w.header {
+		if err := w.cw.Write(w.fields); err != nil {
+			return err
+		}
+		w.header = true
+	}
+	record := make([]string, len(w.fields))
+	for i, f := range w.fields {
+		record[i] = flat[f]
+	}
+	return w.cw.Write(record)
+}
+
+func (w *csvWriter) close() error {
+	if err := w.flush(); err != nil {
+		return err
+	}
+	if !w.header && len(w.fields) > 0 {
+		w.cw.Write(w.fields)
+	}
+	w.cw.Flush()
+	return w.cw.Error()
+}
+
+// flatten adds the attributes of the object to res as strings, with
+// nested objects as dotted names. Null values are "null", which import
+// reads back as null; lists and other values are in JSON.
+func flatten(obj map[string]interface{}, prefix string, res map[string]string) {
+	for k, v := range obj {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			flatten(v, prefix+k+".", res)
+		case nil:
+			res[prefix+k] = "null"
+		case string:
+			res[prefix+k] = v
+		default:
+			bs, _ := json.Marshal(v)
+			res[prefix+k] = string(bs)
+		}
+	}
+}
+
+// selectFields returns an object with only the fields, given as dotted
+// paths, of obj.
+func selectFields(obj map[string]interface{}, fields []string) map[string]interface{} {
+	res := make(map[string]interface{})
+	for _, f := range fields {
+		path := strings.Split(f, ".")
+		if v, ok := lookupPath(obj, path); ok {
+			setPath(res, path, v)
+		}
+	}
+	return res
+}
+
+// lookupPath returns the value at the path of keys in the object.
+func lookupPath(obj map[string]interface{}, path []string) (interface{}, bool) {
+	var v interface{} = obj
+	for _, k := range path {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[k]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
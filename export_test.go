@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestListCommand(t *testing.T) {
+	services := map[string]smdService{
+		"subscriber.list": {Parameters: []smdParameter{{Name: "limit"}, {Name: "offset"}}},
+		"session.list":    {Parameters: []smdParameter{{Name: "maxCount"}}},
+		"account.list":    {Parameters: []smdParameter{{Name: "accountId"}, {Name: "firstName"}}},
+		"group.list":      {},
+	}
+
+	testcases := []struct {
+		typ    string
+		params []interface{}
+		paged  bool
+		sized  bool
+	}{
+		{"subscriber", []interface{}{json.Number("10"), json.Number("20")}, true, true},
+		{"session", []interface{}{json.Number("10")}, false, true},
+		{"account", nil, false, false},
+		{"group", nil, false, false},
+		{"other", []interface{}{json.Number("10"), json.Number("20")}, true, true},
+	}
+
+	for _, tc := range testcases {
+		cmd, paged, sized := listCommand(tc.typ, services, 10, 20)
+		if cmd.Method != tc.typ+".list" || !reflect.DeepEqual(cmd.Params, tc.params) || paged != tc.paged || sized != tc.sized {
+			t.Errorf("Incorrect list command for %q: %v %v, %v, %v != expected %v, %v, %v", tc.typ, cmd.Method, cmd.Params, paged, sized, tc.params, tc.paged, tc.sized)
+		}
+	}
+}
+
+func TestListObjectsUnpaged(t *testing.T) {
+	// Five sessions from a method without an offset, asked for two at a
+	// time
+	var calls []string
+	s, _ := testSession(func(cmd command) (interface{}, int) {
+		calls = append(calls, fmt.Sprint(cmd.Method, " ", cmd.Params))
+		count, _ := cmd.Params[0].(json.Number).Int64()
+		var res []interface{}
+		for i := int64(0); i < count && i < 5; i++ {
+			res = append(res, map[string]interface{}{"id": i})
+		}
+		return res, 0
+	})
+	s.services = map[string]smdService{
+		"session.list": {Parameters: []smdParameter{{Name: "count"}}},
+	}
+
+	n := 0
+	ok, err := s.listObjects("session", 2, func(map[string]interface{}) error {
+		n++
+		return nil
+	})
+	if err != nil || !ok {
+		t.Fatalf("Unexpected list failure: %v", err)
+	}
+	if n != 5 {
+		t.Errorf("Incorrect number of objects %d != expected 5", n)
+	}
+	exp := []string{"session.list [2]", "session.list [4]", "session.list [8]"}
+	if !reflect.DeepEqual(calls, exp) {
+		t.Errorf("Incorrect calls: %q != expected %q", calls, exp)
+	}
+}
+
+func TestObjectWriter(t *testing.T) {
+	objs := []map[string]interface{}{
+		{"subscriberId": "1", "slot": json.Number("3"), "parentOid": nil, "a": map[string]interface{}{"b": "x"}},
+		{"subscriberId": "2", "tags": []interface{}{"p", "q"}},
+	}
+
+	testcases := []struct {
+		format string
+		fields []string
+		out    string
+	}{
+		{formatCSV, nil, "a.b,parentOid,slot,subscriberId,tags\nx,null,3,1,\n,,,2,\"[\"\"p\"\",\"\"q\"\"]\"\n"},
+		{formatCSV, []string{"subscriberId", "a.b"}, "subscriberId,a.b\n1,x\n2,\n"},
+		{formatJSONL, nil, "{\"a\":{\"b\":\"x\"},\"parentOid\":null,\"slot\":3,\"subscriberId\":\"1\"}\n{\"subscriberId\":\"2\",\"tags\":[\"p\",\"q\"]}\n"},
+		{formatJSONL, []string{"subscriberId", "a.b"}, "{\"a\":{\"b\":\"x\"},\"subscriberId\":\"1\"}\n{\"subscriberId\":\"2\"}\n"},
+		{formatJSON, []string{"subscriberId"}, "[\n{\"subscriberId\":\"1\"},\n{\"subscriberId\":\"2\"}\n]\n"},
+	}
+
+	for _, tc := range testcases {
+		var buf bytes.Buffer
+		w := newObjectWriter(&buf, tc.format, tc.fields)
+		for _, obj := range objs {
+			if err := w.write(obj); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.close(); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != tc.out {
+			t.Errorf("Incorrect %s output for fields %v:\n%s\n!= expected\n%s", tc.format, tc.fields, buf.String(), tc.out)
+		}
+	}
+}
+
+func TestCSVWriterColumns(t *testing.T) {
+	// An attribute outside the columns of the sampled objects is an error
+	// rather than left out
+	var buf bytes.Buffer
+	w := newObjectWriter(&buf, formatCSV, nil)
+	for i := 0; i < csvSample; i++ {
+		if err := w.write(map[string]interface{}{"subscriberId": fmt.Sprint(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.write(map[string]interface{}{"subscriberId": "x"}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := w.write(map[string]interface{}{"subscriberId": "y", "hostName": "web1"}); err == nil {
+		t.Errorf("Unexpected nil error for an attribute outside the columns")
+	}
+}
+
+func TestExportFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "psmcli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Five subscribers, listed two at a time
+	var calls []string
+	s, _ := testSession(func(cmd command) (interface{}, int) {
+		calls = append(calls, fmt.Sprint(cmd.Method, " ", cmd.Params))
+		limit, _ := cmd.Params[0].(json.Number).Int64()
+		offset, _ := cmd.Params[1].(json.Number).Int64()
+		var res []interface{}
+		for i := offset; i < offset+limit && i < 5; i++ {
+			res = append(res, map[string]interface{}{"subscriberId": fmt.Sprint(i), "oid": i})
+		}
+		return res, 0
+	})
+
+	file := filepath.Join(dir, "subs.jsonl")
+	ok, err := s.exportFile("--page=2 --fields=subscriberId subscriber " + file)
+	if err != nil || !ok {
+		t.Fatalf("Unexpected export failure: %v", err)
+	}
+
+	expCalls := []string{"subscriber.list [2 0]", "subscriber.list [2 2]", "subscriber.list [2 4]"}
+	if !reflect.DeepEqual(calls, expCalls) {
+		t.Errorf("Incorrect calls: %q != expected %q", calls, expCalls)
+	}
+
+	bs, _ := ioutil.ReadFile(file)
+	exp := "{\"subscriberId\":\"0\"}\n{\"subscriberId\":\"1\"}\n{\"subscriberId\":\"2\"}\n{\"subscriberId\":\"3\"}\n{\"subscriberId\":\"4\"}\n"
+	if string(bs) != exp {
+		t.Errorf("Incorrect export:\n%s\n!= expected\n%s", bs, exp)
+	}
+}
+
+func TestExportFileFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "psmcli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "subs.jsonl")
+	ioutil.WriteFile(file, []byte("previous\n"), 0600)
+
+	// The second page fails, leaving the previous export in place
+	s, _ := testSession(func(cmd command) (interface{}, int) {
+		if offset, _ := cmd.Params[1].(json.Number).Int64(); offset > 0 {
+			return nil, -1
+		}
+		return []interface{}{map[string]interface{}{"subscriberId": "a"}, map[string]interface{}{"subscriberId": "b"}}, 0
+	})
+	if ok, err := s.exportFile("--page=2 subscriber " + file); ok || err != nil {
+		t.Fatalf("Unexpected export result: %v %v", ok, err)
+	}
+
+	if bs, _ := ioutil.ReadFile(file); string(bs) != "previous\n" {
+		t.Errorf("Incorrect file after failed export: %q", bs)
+	}
+	if fis, _ := ioutil.ReadDir(dir); len(fis) != 1 {
+		t.Errorf("Unexpected files left after failed export: %d", len(fis))
+	}
+}
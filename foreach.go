@@ -0,0 +1,191 @@
+// psmcli
+// Copyright (C) 2014 Procera Networks, Inc.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// defaultMaxCalls is the number of mutating calls a foreach loop may make
+// unless another limit is given with --max.
+const defaultMaxCalls = 100
+
+var (
+	foreachExp = regexp.MustCompile(`^foreach\s+(?:--max=([0-9]+)\s+)?([A-Za-z_][A-Za-z0-9_]*)\s+in\s+`)
+	doExp      = regexp.MustCompile(`^\s+do\s+`)
+)
+
+// readOnlyPrefixes are the beginnings of method names, after the service,
+// that don't change anything in PSM. Other methods count against the
+// foreach limit.
+var readOnlyPrefixes = []string{"get", "list", "find", "count", "is", "has", "search", "describe", "show", "version", "hostname", "smd", "echo", "ping"}
+
+// foreach runs the body once for each element of a list, given as a
+// command in parentheses or a variable:
+//
+//	foreach [--max=N] x in (command) do body
+//	foreach [--max=N] x in $list do body
+//
+// The element is available to the body as $x. Progress is printed before
+// each element and the failed elements listed at the end.
+func (s *session) foreach(line string) (bool, error) {
+	m := foreachExp.FindStringSubmatch(line)
+	if m == nil {
+		fmt.Fprintln(s.out, "Usage: foreach [--max=N] name in (command) do command")
+		return false, nil
+	}
+
+	max := defaultMaxCalls
+	if m[1] != "" {
+		max, _ = strconv.Atoi(m[1])
+	}
+	name := m[2]
+
+	rest := line[len(m[0]):]
+	var source string
+	if strings.HasPrefix(rest, "(") {
+		end := closingParen(rest)
+		if end < 0 {
+			fmt.Fprintln(s.out, "foreach: missing ) after the command")
+			return false, nil
+		}
+		source, rest = rest[1:end], rest[end+1:]
+	} else {
+		end := strings.IndexFunc(rest, isSpace)
+		if end < 0 {
+			end = len(rest)
+		}
+		source, rest = rest[:end], rest[end:]
+	}
+
+	dm := doExp.FindStringIndex(rest)
+	if dm == nil || strings.TrimSpace(rest[dm[1]:]) == "" {
+		fmt.Fprintln(s.out, "foreach: expected do and a command after", strings.TrimSpace(source))
+		return false, nil
+	}
+	body := rest[dm[1]:]
+
+	v, ok, err := s.evaluate(strings.TrimSpace(source))
+	if err != nil || !ok {
+		return false, err
+	}
+	items, ok := v.([]interface{})
+	if !ok && v != nil {
+		fmt.Fprintln(s.out, "foreach:", strings.TrimSpace(source), "is not a list")
+		return false, nil
+	}
+
+	// A loop within a loop may not make more calls than its parent has
+	// left.
+
+	parentMax, parentCalls := s.maxCalls, s.calls
+	if parentMax > 0 && (max == 0 || parentMax-parentCalls < max) {
+		max = parentMax - parentCalls
+	}
+	s.maxCalls, s.calls = max, 0
+	defer func() {
+		s.maxCalls, s.calls = parentMax, parentCalls+s.calls
+	}()
+
+	var failed []int
+	stopped := false
+	for i, item := range items {
+		if s.maxCalls > 0 && s.calls >= s.maxCalls {
+			fmt.Fprintf(s.out, "foreach: stopped before item %d of %d; %d mutating calls made (raise the limit with --max=N)\n", i+1, len(items), s.calls)
+			stopped = true
+			break
+		}
+
+		fmt.Fprintf(s.out, "[%d/%d] %s\n", i+1, len(items), summary(item))
+		s.vars[name] = item
+		ok, err := s.execute(body)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			failed = append(failed, i)
+		}
+	}
+
+	if len(failed) > 0 {
+		fmt.Fprintf(s.out, "foreach: %d of %d items failed:\n", len(failed), len(items))
+		for _, i := range failed {
+			fmt.Fprintf(s.out, "  item %d: %s\n", i+1, summary(items[i]))
+		}
+	}
+	return len(failed) == 0 && !stopped, nil
+}
+
+// allowCall returns whether a call to the method may be made within the
+// limit set by foreach, counting it if it's a mutating call.
+func (s *session) allowCall(method string) bool {
+	if !isMutating(method) {
+		return true
+	}
+	if s.maxCalls > 0 && s.calls >= s.maxCalls {
+		fmt.Fprintf(s.out, "Not calling %s; the limit of %d mutating calls is reached\n", method, s.maxCalls)
+		return false
+	}
+	s.calls++
+	return true
+}
+
+// isMutating returns true unless the method is known not to change
+// anything.
+func isMutating(method string) bool {
+	part := strings.ToLower(methodPart(method))
+	for _, p := range readOnlyPrefixes {
+		if strings.HasPrefix(part, p) {
+			return false
+		}
+	}
+	return true
+}
+
+// closingParen returns the index of the parenthesis closing the one that
+// starts s, or -1. Quotes are respected at the outer level only, as within
+// LDAP filters they have no special meaning.
+func closingParen(s string) int {
+	depth := 0
+	var quote rune
+	escaped := false
+	for i, r := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case depth == 1 && r == '\\' && quote != '\'':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case depth == 1 && (r == '"' || r == '\''):
+			quote = r
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// summary returns the value as compact JSON, shortened to fit on a line.
+func summary(v interface{}) string {
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	if len(bs) > 64 {
+		return string(bs[:61]) + "..."
+	}
+	return string(bs)
+}
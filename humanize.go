@@ -0,0 +1,79 @@
+// psmcli
+// Copyright (C) 2014 Procera Networks, Inc.
+
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// humanize returns a copy of v where timestamps are converted to local time
+// with a relative age. OIDs are left as they are, as their layout isn't
+// documented. The result is meant for display only and is never sent
+// anywhere.
+func humanize(v interface{}, now time.Time) interface{} {
+	return humanizeField("", v, now)
+}
+
+func humanizeField(key string, v interface{}, now time.Time) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			res[k] = humanizeField(k, val, now)
+		}
+		return res
+
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for i, val := range v {
+			res[i] = humanizeField(key, val, now)
+		}
+		return res
+
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return v
+		}
+		return humanTime(key, t, now)
+	}
+
+	return v
+}
+
+// humanTime returns the timestamp in local time together with the time
+// relative to now. Expiry times read as "expires in 6d" or "expired 2h ago".
+func humanTime(key string, t, now time.Time) string {
+	local := t.Local().Format("2006-01-02 15:04:05 MST")
+	d := t.Sub(now)
+
+	var rel string
+	switch {
+	case key == "expire" && d >= 0:
+		rel = "expires in " + shortDuration(d)
+	case key == "expire":
+		rel = "expired " + shortDuration(-d) + " ago"
+	case d >= 0:
+		rel = "in " + shortDuration(d)
+	default:
+		rel = shortDuration(-d) + " ago"
+	}
+
+	return local + " (" + rel + ")"
+}
+
+// shortDuration returns the duration rounded down to the largest whole unit
+// of days, hours, minutes or seconds, e.g. "6d".
+func shortDuration(d time.Duration) string {
+	switch {
+	case d >= 24*time.Hour:
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d >= time.Minute:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	}
+	return fmt.Sprintf("%ds", d/time.Second)
+}
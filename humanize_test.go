@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHumanize(t *testing.T) {
+	now, _ := time.Parse(time.RFC3339, "2014-10-26T10:47:16Z")
+	in := map[string]interface{}{
+		"creationTime": "2014-09-15T08:17:08.623Z",
+		"expire":       "2014-11-01T10:47:16.207Z",
+		"oid":          json.Number("288230376151715606"),
+		"parentOid":    nil,
+		"slot":         json.Number("80"),
+		"hostName":     "syno-2",
+	}
+
+	res := humanize(in, now).(map[string]interface{})
+
+	if !strings.HasSuffix(res["creationTime"].(string), "(41d ago)") {
+		t.Errorf("Incorrect creationTime %q", res["creationTime"])
+	}
+	if !strings.HasSuffix(res["expire"].(string), "(expires in 6d)") {
+		t.Errorf("Incorrect expire %q", res["expire"])
+	}
+	for _, key := range []string{"oid", "parentOid", "slot", "hostName"} {
+		if !reflect.DeepEqual(res[key], in[key]) {
+			t.Errorf("Unexpected change of %s: %v", key, res[key])
+		}
+	}
+
+	// The original must be untouched
+	if in["oid"] != json.Number("288230376151715606") {
+		t.Error("Original value was modified")
+	}
+}
+
+func TestShortDuration(t *testing.T) {
+	testcases := []struct {
+		d time.Duration
+		s string
+	}{
+		{5 * time.Second, "5s"},
+		{90 * time.Second, "1m"},
+		{25 * time.Hour, "1d"},
+		{6*24*time.Hour + 23*time.Hour, "6d"},
+	}
+
+	for _, tc := range testcases {
+		if s := shortDuration(tc.d); s != tc.s {
+			t.Errorf("Incorrect duration for %v: %s != %s", tc.d, s, tc.s)
+		}
+	}
+}
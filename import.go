@@ -0,0 +1,522 @@
+// psmcli
+// Copyright (C) 2014 Procera Networks, Inc.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The file formats of import and export, besides formatJSON.
+const (
+	formatCSV   = "csv"
+	formatJSONL = "jsonl"
+)
+
+func knownFormat(f string) bool {
+	return f == formatCSV || f == formatJSONL || f == formatJSON
+}
+
+// errorColumn is the column, or member, added to each row in the error
+// report. It's ignored when importing, so that a fixed report can be
+// imported again.
+const errorColumn = "_error"
+
+// The import modes.
+const (
+	modeUpsert = "upsert"
+	modeCreate = "create"
+	modeUpdate = "update"
+)
+
+type importOptions struct {
+	typ         string
+	file        string
+	format      string
+	mode        string
+	dryRun      bool
+	concurrency int
+	errors      string
+}
+
+// importRow is one row of an import file.
+type importRow struct {
+	num    int
+	attrs  attributes
+	record []string // the CSV record
+	line   []byte   // or the JSONL line
+	err    error
+}
+
+// parseImport parses the arguments to the import command:
+//
+//	import [--dry-run] [--mode=upsert|create|update] [--concurrency=N]
+//	       [--format=csv|jsonl|json] [--errors=file] type file
+func parseImport(args string) (importOptions, error) {
+	opts := importOptions{mode: modeUpsert, concurrency: 1}
+	words, err := splitWords(args)
+	if err != nil {
+		return opts, err
+	}
+
+	var rest []string
+	for _, w := range words {
+		if !strings.HasPrefix(w, "--") {
+			rest = append(rest, unquote(w))
+			continue
+		}
+		name, val := w[2:], ""
+		if eq := strings.IndexByte(name, '='); eq >= 0 {
+			name, val = name[:eq], unquote(name[eq+1:])
+		}
+		switch name {
+		case "dry-run":
+			opts.dryRun = true
+		case "mode":
+			switch val {
+			case modeUpsert, modeCreate, modeUpdate:
+				opts.mode = val
+			default:
+				return opts, fmt.Errorf("unknown mode %q; use upsert, create or update", val)
+			}
+		case "concurrency":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 1 {
+				return opts, fmt.Errorf("concurrency must be a positive number")
+			}
+			opts.concurrency = n
+		case "format":
+			if !knownFormat(val) {
+				return opts, fmt.Errorf("unknown format %q; use csv, jsonl or json", val)
+			}
+			opts.format = val
+		case "errors":
+			opts.errors = val
+		default:
+			return opts, fmt.Errorf("unknown option --%s", name)
+		}
+	}
+
+	if len(rest) != 2 {
+		return opts, fmt.Errorf("Usage: import [--dry-run] [--mode=upsert|create|update] [--concurrency=N] [--format=csv|jsonl|json] [--errors=file] type file")
+	}
+	opts.typ, opts.file = rest[0], rest[1]
+	if opts.format == "" {
+		opts.format = fileFormat(opts.file)
+	}
+	if opts.errors == "" {
+		ext := filepath.Ext(opts.file)
+		opts.errors = strings.TrimSuffix(opts.file, ext) + ".errors" + ext
+	}
+	return opts, nil
+}
+
+// fileFormat returns the format of the file by its extension; CSV unless
+// it's .jsonl, .ndjson or .json.
+func fileFormat(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".jsonl", ".ndjson":
+		return formatJSONL
+	case ".json":
+		return formatJSON
+	}
+	return formatCSV
+}
+
+// readImport reads the rows of the file, and the CSV header. Rows that
+// can't be parsed are returned with their error set.
+func readImport(r io.Reader, format string) ([]string, []importRow, error) {
+	switch format {
+	case formatJSONL:
+		rows, err := readJSONL(r)
+		return nil, rows, err
+	case formatJSON:
+		rows, err := readJSON(r)
+		return nil, rows, err
+	}
+	return readCSV(r)
+}
+
+// readCSV reads rows whose attributes are named by the header row. Dotted
+// names create nested objects, empty cells are left out and "null" clears
+// the attribute.
+func readCSV(r io.Reader) ([]string, []importRow, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	skip := -1
+	for i, name := range header {
+		if name == errorColumn {
+			skip = i
+			header = append(header[:i:i], header[i+1:]...)
+			break
+		}
+	}
+
+	var rows []importRow
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return header, rows, nil
+		}
+		if skip >= 0 && skip < len(record) {
+			record = append(record[:skip:skip], record[skip+1:]...)
+		}
+		row := importRow{num: len(rows) + 2, record: record}
+		if err != nil {
+			if _, ok := err.(*csv.ParseError); !ok {
+				return nil, nil, err
+			}
+			row.err = err
+			rows = append(rows, row)
+			continue
+		}
+
+		obj := attributes{}
+		for i, val := range record {
+			if val == "" {
+				continue
+			}
+			var v interface{} = val
+			if val == "null" {
+				v = nil
+			}
+			if err := setPath(obj, strings.Split(header[i], "."), v); err != nil {
+				row.err = err
+				break
+			}
+		}
+		row.attrs = obj
+		rows = append(rows, row)
+	}
+}
+
+// readJSONL reads rows of one JSON object per line.
+func readJSONL(r io.Reader) ([]importRow, error) {
+	var rows []importRow
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	num := 0
+	for sc.Scan() {
+		num++
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		rows = append(rows, decodeRow(num, append([]byte(nil), line...)))
+	}
+	return rows, sc.Err()
+}
+
+// readJSON reads rows from a JSON array of objects.
+func readJSON(r io.Reader) ([]importRow, error) {
+	dec := json.NewDecoder(r)
+	if t, err := dec.Token(); err != nil {
+		return nil, err
+	} else if t != json.Delim('[') {
+		return nil, fmt.Errorf("expected a JSON array")
+	}
+
+	var rows []importRow
+	for dec.More() {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, err
+		}
+		rows = append(rows, decodeRow(len(rows)+1, raw))
+	}
+	return rows, nil
+}
+
+// decodeRow decodes the JSON object of a row.
+func decodeRow(num int, line []byte) importRow {
+	row := importRow{num: num, line: line}
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+	var obj map[string]interface{}
+	if err := dec.Decode(&obj); err != nil {
+		row.err = err
+	} else if obj == nil {
+		row.err = fmt.Errorf("not an object")
+	} else {
+		delete(obj, errorColumn)
+		row.attrs = attributes(obj)
+	}
+	return row
+}
+
+// importCommand returns the command importing the row. When the mode is
+// upsert, objects whose application IDs are among the existing ones are
+// updated and others created.
+func importCommand(row importRow, opts importOptions, existing map[string]interface{}, services map[string]smdService) (command, error) {
+	aid := objectAid(opts.typ, row.attrs)
+	mode := opts.mode
+	if mode == modeUpsert {
+		mode = modeCreate
+		if _, ok := existing[aid]; ok && aid != "" {
+			mode = modeUpdate
+		}
+	}
+
+	if mode == modeCreate {
+		return objectCommand(services, methodCreate, opts.typ, row.attrs)
+	}
+	if aid == "" {
+		return command{}, fmt.Errorf("missing %s", aidAttribute(opts.typ))
+	}
+	attrs := make(attributes, len(row.attrs))
+	for k, v := range row.attrs {
+		if k != aidAttribute(opts.typ) {
+			attrs[k] = v
+		}
+	}
+	return objectCommand(services, methodUpdateByAid, opts.typ, aid, attrs)
+}
+
+// importFile runs the import command, creating or updating an object for
+// each row in the file. Up to the given number of requests are sent
+// before waiting for responses. Failed rows are written to the error
+// report.
+func (s *session) importFile(args string) (bool, error) {
+	opts, err := parseImport(args)
+	if err != nil {
+		fmt.Fprintln(s.out, err)
+		return false, nil
+	}
+
+	fd, err := os.Open(opts.file)
+	if err != nil {
+		fmt.Fprintln(s.out, err)
+		return false, nil
+	}
+	header, rows, err := readImport(fd, opts.format)
+	fd.Close()
+	if err != nil {
+		fmt.Fprintf(s.out, "%s: %v\n", opts.file, err)
+		return false, nil
+	}
+	if header != nil {
+		// CSV values are strings, converted to the types of the
+		// attributes of the objects on the node
+		types, err := s.attributeTypes(opts.typ)
+		if err != nil {
+			return false, err
+		}
+		for i, row := range rows {
+			if row.err == nil {
+				rows[i].attrs, rows[i].err = coerceAttributes(row.attrs, types)
+			}
+		}
+	}
+
+	// Upserts update the objects on the node and create the others, so
+	// that update errors are reported rather than taken as missing objects
+	var existing map[string]interface{}
+	if opts.mode == modeUpsert {
+		all, ok, err := s.existingObjects([]string{opts.typ})
+		if err != nil || !ok {
+			return false, err
+		}
+		if existing, ok = all[opts.typ]; !ok {
+			fmt.Fprintf(s.out, "%s objects can't be listed to find those to update; use --mode=create or --mode=update\n", opts.typ)
+			return false, nil
+		}
+	}
+
+	failed := make(map[int]error)
+	p := newProgress(s.out, "imported", len(rows))
+	if opts.dryRun {
+		p.out = ioutil.Discard
+	}
+
+	inflight := make(map[int]int) // the rows by request ID
+
+	send := func(i int, cmd command) error {
+		cmd.ID = s.id
+		s.id++
+		if s.verbose {
+			bs, _ := json.Marshal(cmd)
+			fmt.Fprintf(s.out, "> %s\n", bs)
+		}
+		if err := s.conn.send(cmd); err != nil {
+			if _, ok := err.(encodeError); ok {
+				failed[i] = err
+				p.done(false)
+				return nil
+			}
+			return err
+		}
+		inflight[cmd.ID] = i
+		return nil
+	}
+
+	next := 0
+	for next < len(rows) || len(inflight) > 0 {
+		// Fill the window of outstanding requests
+
+		for next < len(rows) && len(inflight) < opts.concurrency {
+			i := next
+			next++
+			row := rows[i]
+			if row.err != nil {
+				failed[i] = row.err
+				p.done(false)
+				if opts.dryRun {
+					fmt.Fprintf(s.out, "row %d: %v\n", row.num, row.err)
+				}
+				continue
+			}
+			cmd, err := importCommand(row, opts, existing, s.services)
+			if err != nil {
+				failed[i] = err
+				p.done(false)
+				if opts.dryRun {
+					fmt.Fprintf(s.out, "row %d: %v\n", row.num, err)
+				}
+				continue
+			}
+			if opts.dryRun {
+				bs, _ := json.Marshal(cmd.Params)
+				fmt.Fprintf(s.out, "row %d: %s %s\n", row.num, cmd.Method, bs)
+				p.done(true)
+				continue
+			}
+			if err := send(i, cmd); err != nil {
+				return false, err
+			}
+		}
+		if len(inflight) == 0 {
+			continue
+		}
+
+		res, err := s.conn.receive()
+		if err != nil {
+			return false, err
+		}
+		row, ok := inflight[res.ID]
+		if !ok {
+			continue
+		}
+		delete(inflight, res.ID)
+
+		if res.Error.Code == 0 {
+			p.done(true)
+			continue
+		}
+		failed[row] = fmt.Errorf("%s", res.Error.Message)
+		p.done(false)
+	}
+	p.finish()
+
+	if opts.dryRun {
+		fmt.Fprintf(s.out, "%d of %d rows would fail\n", len(failed), len(rows))
+		return len(failed) == 0, nil
+	}
+	if len(failed) == 0 {
+		return true, nil
+	}
+	if err := writeErrorReport(opts.errors, opts.format, header, rows, failed); err != nil {
+		fmt.Fprintln(s.out, err)
+	} else {
+		fmt.Fprintf(s.out, "%d of %d rows failed; see %s\n", len(failed), len(rows), opts.errors)
+	}
+	return false, nil
+}
+
+// writeErrorReport writes the failed rows to the file in the format they
+// were read, with the error added to each.
+func writeErrorReport(name, format string, header []string, rows []importRow, failed map[int]error) error {
+	var buf bytes.Buffer
+	if format == formatCSV {
+		cw := csv.NewWriter(&buf)
+		cw.Write(append([]string{errorColumn}, header...))
+		for i, row := range rows {
+			if err, ok := failed[i]; ok {
+				cw.Write(append([]string{err.Error()}, row.record...))
+			}
+		}
+		cw.Flush()
+	} else {
+		w := newObjectWriter(&buf, format, nil)
+		for i, row := range rows {
+			err, ok := failed[i]
+			if !ok {
+				continue
+			}
+			obj := map[string]interface{}{errorColumn: err.Error()}
+			if row.attrs != nil {
+				for k, v := range row.attrs {
+					obj[k] = v
+				}
+			} else {
+				obj["_line"] = string(row.line)
+			}
+			if err := w.write(obj); err != nil {
+				return err
+			}
+		}
+		if err := w.close(); err != nil {
+			return err
+		}
+	}
+	return ioutil.WriteFile(name, buf.Bytes(), 0644)
+}
+
+// progress prints a running count of finished items, at most a few times
+// a second.
+type progress struct {
+	out    io.Writer
+	verb   string
+	total  int
+	ok     int
+	failed int
+	last   time.Time
+}
+
+func newProgress(out io.Writer, verb string, total int) *progress {
+	return &progress{out: out, verb: verb, total: total}
+}
+
+// done counts a finished item.
+func (p *progress) done(ok bool) {
+	if ok {
+		p.ok++
+	} else {
+		p.failed++
+	}
+	if time.Since(p.last) > 200*time.Millisecond {
+		p.print("\r")
+		p.last = time.Now()
+	}
+}
+
+// finish prints the final count.
+func (p *progress) finish() {
+	p.print("\r")
+	fmt.Fprintln(p.out)
+}
+
+func (p *progress) print(prefix string) {
+	if p.total == 0 {
+		// Unknown total
+		fmt.Fprintf(p.out, "%s%d %s", prefix, p.ok+p.failed, p.verb)
+		return
+	}
+	fmt.Fprintf(p.out, "%s%d/%d %s, %d failed", prefix, p.ok+p.failed, p.total, p.verb, p.failed)
+}
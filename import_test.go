@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParseImport(t *testing.T) {
+	testcases := []struct {
+		args string
+		opts importOptions
+		err  bool
+	}{
+		{"subscriber subs.csv", importOptions{typ: "subscriber", file: "subs.csv", format: "csv", mode: "upsert", concurrency: 1, errors: "subs.errors.csv"}, false},
+		{"--dry-run --mode=create --concurrency=8 subscriber subs.jsonl", importOptions{typ: "subscriber", file: "subs.jsonl", format: "jsonl", mode: "create", dryRun: true, concurrency: 8, errors: "subs.errors.jsonl"}, false},
+		{"--format=jsonl --errors=bad.txt subscriber subs.txt", importOptions{typ: "subscriber", file: "subs.txt", format: "jsonl", mode: "upsert", concurrency: 1, errors: "bad.txt"}, false},
+		{"subscriber", importOptions{}, true},
+		{"--mode=delete subscriber subs.csv", importOptions{}, true},
+		{"--concurrency=0 subscriber subs.csv", importOptions{}, true},
+		{"--force subscriber subs.csv", importOptions{}, true},
+	}
+
+	for _, tc := range testcases {
+		opts, err := parseImport(tc.args)
+		if tc.err {
+			if err == nil {
+				t.Errorf("Unexpected nil error for %q", tc.args)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %v", tc.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(opts, tc.opts) {
+			t.Errorf("Incorrect options for %q: %+v != expected %+v", tc.args, opts, tc.opts)
+		}
+	}
+}
+
+func TestReadImport(t *testing.T) {
+	testcases := []struct {
+		in     string
+		format string
+		attrs  []attributes // nil for rows that fail
+	}{
+		{
+			"subscriberId,hostName,persistent,a.b\n1,web1,true,x\n2,,null,\n",
+			formatCSV,
+			[]attributes{
+				{"subscriberId": "1", "hostName": "web1", "persistent": "true", "a": map[string]interface{}{"b": "x"}},
+				{"subscriberId": "2", "persistent": nil},
+			},
+		},
+		{
+			"_error,subscriberId,slot\nfailed,1,3\n1,2\nfailed,3,x\n",
+			formatCSV,
+			[]attributes{{"subscriberId": "1", "slot": "3"}, nil, {"subscriberId": "3", "slot": "x"}},
+		},
+		{
+			"{\"subscriberId\": \"1\", \"slot\": 3}\n\n{\"_error\": \"failed\", \"subscriberId\": \"2\"}\n{bad\n[1]\n",
+			formatJSONL,
+			[]attributes{{"subscriberId": "1", "slot": json.Number("3")}, {"subscriberId": "2"}, nil, nil},
+		},
+		{
+			"[{\"subscriberId\": \"1\"},\n {\"subscriberId\": \"2\", \"a\": {\"b\": true}}, 3]",
+			formatJSON,
+			[]attributes{{"subscriberId": "1"}, {"subscriberId": "2", "a": map[string]interface{}{"b": true}}, nil},
+		},
+	}
+
+	for _, tc := range testcases {
+		_, rows, err := readImport(strings.NewReader(tc.in), tc.format)
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %v", tc.in, err)
+			continue
+		}
+		if len(rows) != len(tc.attrs) {
+			t.Errorf("Incorrect number of rows for %q: %d != expected %d", tc.in, len(rows), len(tc.attrs))
+			continue
+		}
+		for i, row := range rows {
+			if tc.attrs[i] == nil {
+				if row.err == nil {
+					t.Errorf("Unexpected nil error for row %d of %q", i, tc.in)
+				}
+				continue
+			}
+			if row.err != nil {
+				t.Errorf("Unexpected error for row %d of %q: %v", i, tc.in, row.err)
+				continue
+			}
+			if !reflect.DeepEqual(row.attrs, tc.attrs[i]) {
+				t.Errorf("Incorrect row %d of %q: %v != expected %v", i, tc.in, row.attrs, tc.attrs[i])
+			}
+		}
+	}
+}
+
+func TestImportFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "psmcli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "subs.csv")
+	data := "subscriberId,hostName\n1,a\n2,b\n3,c\n,d\n"
+	if err := ioutil.WriteFile(file, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []struct {
+		args   string
+		calls  []string
+		errors string
+		ok     bool
+	}{
+		// Subscribers 1 and 3 exist and are updated, which fails for 3;
+		// the others are created
+		{
+			"subscriber " + file,
+			[]string{"object.create [subscriber map[hostName:b subscriberId:2]]", "object.create [subscriber map[hostName:d]]", "object.updateByAid [subscriber 1 map[hostName:a]]", "object.updateByAid [subscriber 3 map[hostName:c]]", "subscriber.list [10 0]", "subscriber.list [1000 0]"},
+			"_error,subscriberId,hostName\nfailed,3,c\n",
+			false,
+		},
+		{
+			"--concurrency=3 subscriber " + file,
+			[]string{"object.create [subscriber map[hostName:b subscriberId:2]]", "object.create [subscriber map[hostName:d]]", "object.updateByAid [subscriber 1 map[hostName:a]]", "object.updateByAid [subscriber 3 map[hostName:c]]", "subscriber.list [10 0]", "subscriber.list [1000 0]"},
+			"_error,subscriberId,hostName\nfailed,3,c\n",
+			false,
+		},
+		{
+			"--mode=update subscriber " + file,
+			[]string{"object.updateByAid [subscriber 1 map[hostName:a]]", "object.updateByAid [subscriber 2 map[hostName:b]]", "object.updateByAid [subscriber 3 map[hostName:c]]", "subscriber.list [10 0]"},
+			"_error,subscriberId,hostName\nfailed,2,b\nfailed,3,c\nmissing subscriberId,,d\n",
+			false,
+		},
+		{
+			"--dry-run subscriber " + file,
+			[]string{"subscriber.list [10 0]", "subscriber.list [1000 0]"},
+			"",
+			true,
+		},
+	}
+
+	for _, tc := range testcases {
+		os.Remove(filepath.Join(dir, "subs.errors.csv"))
+
+		var mut sync.Mutex
+		var calls []string
+		s, _ := testSession(func(cmd command) (interface{}, int) {
+			mut.Lock()
+			defer mut.Unlock()
+			calls = append(calls, fmt.Sprint(cmd.Method, " ", cmd.Params))
+			if cmd.Method == "subscriber.list" {
+				return []interface{}{map[string]interface{}{"subscriberId": "1"}, map[string]interface{}{"subscriberId": "3"}}, 0
+			}
+			if cmd.Method == methodUpdateByAid && cmd.Params[1] != "1" {
+				return nil, -1
+			}
+			return nil, 0
+		})
+
+		ok, err := s.importFile(tc.args)
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %v", tc.args, err)
+			continue
+		}
+		if ok != tc.ok {
+			t.Errorf("Incorrect result for %q: %v != expected %v", tc.args, ok, tc.ok)
+		}
+
+		mut.Lock()
+		sort.Strings(calls)
+		if !reflect.DeepEqual(calls, tc.calls) {
+			t.Errorf("Incorrect calls for %q:\n%q\n!= expected\n%q", tc.args, calls, tc.calls)
+		}
+		mut.Unlock()
+
+		bs, _ := ioutil.ReadFile(filepath.Join(dir, "subs.errors.csv"))
+		if string(bs) != tc.errors {
+			t.Errorf("Incorrect error report for %q: %q != expected %q", tc.args, bs, tc.errors)
+		}
+	}
+}
+
+func TestImportFileTypes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "psmcli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "subs.csv")
+	if err := ioutil.WriteFile(file, []byte("subscriberId,slot,persistent,hostName\n1,3,false,007\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// CSV values get the types of the attributes of the objects on the
+	// node
+	var attrs interface{}
+	s, out := testSession(func(cmd command) (interface{}, int) {
+		switch cmd.Method {
+		case "subscriber.list":
+			return []interface{}{map[string]interface{}{"subscriberId": "1", "slot": 1, "persistent": true, "hostName": "web1"}}, 0
+		case methodUpdateByAid:
+			attrs = cmd.Params[2]
+		}
+		return nil, 0
+	})
+	if ok, err := s.importFile("subscriber " + file); !ok || err != nil {
+		t.Fatalf("Unexpected failure: %v %v\n%s", ok, err, out)
+	}
+	exp := map[string]interface{}{"slot": json.Number("3"), "persistent": false, "hostName": "007"}
+	if !reflect.DeepEqual(attrs, exp) {
+		t.Errorf("Incorrect attributes %#v != expected %#v", attrs, exp)
+	}
+}
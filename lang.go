@@ -0,0 +1,426 @@
+// psmcli
+// Copyright (C) 2014 Procera Networks, Inc.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Scripts may use a few statements in addition to commands:
+//
+//	if condition        conditional execution, with optional
+//	  ...               "else if condition" and "else" parts
+//	else
+//	  ...
+//	end
+//
+//	func name a b       defines a function, called as "name x y" with the
+//	  ...               arguments available as $a and $b, until "end" or
+//	end                 "return"
+//
+//	assert condition    stops the script with exit code 1 if false
+//	expect condition    reports a failure if false, making the exit code 1
+//	exit [code]         stops the script
+//
+// Conditions are as for "where" (see cond.go), with variables and command
+// results in parentheses as operands, e.g.
+//
+//	if (system version) ~ ^6 and $sub.persistent == true
+
+// A statement is a command line or one of the script statements.
+type statement struct {
+	line   int    // line number in the script
+	kind   string // "cmd", "if", "func", "assert", "expect", "exit" or "return"
+	text   string // the command, condition or exit code
+	name   string // the function name
+	params []string
+	body   []statement // statements of the function, or run if the condition holds
+	orelse []statement // statements run if the condition doesn't hold
+}
+
+// A scriptLine is a complete command line, with continuation lines joined,
+// and the number of the line it starts on.
+type scriptLine struct {
+	num  int
+	text string
+}
+
+// scriptExit stops a script with the exit code.
+type scriptExit int
+
+func (e scriptExit) Error() string {
+	return fmt.Sprintf("exit %d", int(e))
+}
+
+// errReturn returns from a function.
+var errReturn = errors.New("return outside of function")
+
+var identExp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// parseScript parses the lines into statements.
+func parseScript(lines []scriptLine) ([]statement, error) {
+	stmts, i, term, err := parseBlock(lines, 0)
+	if err != nil {
+		return nil, err
+	}
+	if term != "" {
+		return nil, fmt.Errorf("line %d: %s without if or func", lines[i].num, term)
+	}
+	return stmts, nil
+}
+
+// parseBlock parses statements starting at lines[i], until the end of the
+// lines or an "else" or "end" line. It returns the statements, the index
+// and the first word of the line that ended the block, if any.
+func parseBlock(lines []scriptLine, i int) ([]statement, int, string, error) {
+	var stmts []statement
+	for i < len(lines) {
+		l := lines[i]
+		word, rest := firstWord(l.text)
+		switch word {
+		case "else", "end":
+			return stmts, i, word, nil
+
+		case "if":
+			st, next, err := parseIf(lines, i, rest)
+			if err != nil {
+				return nil, 0, "", err
+			}
+			stmts = append(stmts, st)
+			i = next
+
+		case "func":
+			fields := strings.Fields(rest)
+			if len(fields) == 0 {
+				return nil, 0, "", fmt.Errorf("line %d: missing function name", l.num)
+			}
+			for _, f := range fields {
+				if !identExp.MatchString(f) {
+					return nil, 0, "", fmt.Errorf("line %d: bad function or parameter name %q", l.num, f)
+				}
+			}
+			body, next, term, err := parseBlock(lines, i+1)
+			if err != nil {
+				return nil, 0, "", err
+			}
+			if term != "end" {
+				return nil, 0, "", fmt.Errorf("line %d: func without end", l.num)
+			}
+			stmts = append(stmts, statement{line: l.num, kind: "func", name: fields[0], params: fields[1:], body: body})
+			i = next + 1
+
+		case "assert", "expect":
+			if rest == "" {
+				return nil, 0, "", fmt.Errorf("line %d: missing condition after %s", l.num, word)
+			}
+			stmts = append(stmts, statement{line: l.num, kind: word, text: rest})
+			i++
+
+		case "exit", "return":
+			if word == "exit" && rest != "" {
+				if _, err := strconv.Atoi(rest); err != nil {
+					return nil, 0, "", fmt.Errorf("line %d: bad exit code %q", l.num, rest)
+				}
+			} else if rest != "" {
+				return nil, 0, "", fmt.Errorf("line %d: unexpected %s after return", l.num, rest)
+			}
+			stmts = append(stmts, statement{line: l.num, kind: word, text: rest})
+			i++
+
+		default:
+			stmts = append(stmts, statement{line: l.num, kind: "cmd", text: l.text})
+			i++
+		}
+	}
+	return stmts, i, "", nil
+}
+
+// parseIf parses the if statement at lines[i], with the given condition.
+// It returns the statement and the index of the line after its end.
+func parseIf(lines []scriptLine, i int, cond string) (statement, int, error) {
+	l := lines[i]
+	if cond == "" {
+		return statement{}, 0, fmt.Errorf("line %d: missing condition after if", l.num)
+	}
+
+	st := statement{line: l.num, kind: "if", text: cond}
+	body, next, term, err := parseBlock(lines, i+1)
+	if err != nil {
+		return statement{}, 0, err
+	}
+	st.body = body
+
+	switch term {
+	case "end":
+		return st, next + 1, nil
+
+	case "else":
+		_, rest := firstWord(lines[next].text)
+		if word, cond := firstWord(rest); word == "if" {
+			// "else if" is an if within the else part, sharing its end
+			nested, after, err := parseIf(lines, next, cond)
+			if err != nil {
+				return statement{}, 0, err
+			}
+			st.orelse = []statement{nested}
+			return st, after, nil
+		}
+		if rest != "" {
+			return statement{}, 0, fmt.Errorf("line %d: unexpected %s after else", lines[next].num, rest)
+		}
+
+		orelse, after, term, err := parseBlock(lines, next+1)
+		if err != nil {
+			return statement{}, 0, err
+		}
+		if term != "end" {
+			return statement{}, 0, fmt.Errorf("line %d: if without end", l.num)
+		}
+		st.orelse = orelse
+		return st, after + 1, nil
+	}
+
+	return statement{}, 0, fmt.Errorf("line %d: if without end", l.num)
+}
+
+// firstWord returns the first space separated word of the line and the
+// rest of it.
+func firstWord(line string) (string, string) {
+	line = strings.TrimSpace(line)
+	if i := strings.IndexFunc(line, isSpace); i >= 0 {
+		return line[:i], strings.TrimSpace(line[i:])
+	}
+	return line, ""
+}
+
+// runBlock runs the statements and returns whether the last command
+// succeeded. Besides connection failures, the error may be a scriptExit or
+// errReturn.
+func (s *session) runBlock(stmts []statement) (bool, error) {
+	ok := true
+	for _, st := range stmts {
+		var err error
+		switch st.kind {
+		case "if":
+			var holds bool
+			if holds, _, err = s.test(st); err != nil {
+				return false, err
+			}
+			if holds {
+				ok, err = s.runBlock(st.body)
+			} else if st.orelse != nil {
+				ok, err = s.runBlock(st.orelse)
+			}
+
+		case "func":
+			if s.funcs == nil {
+				s.funcs = make(map[string]statement)
+			}
+			s.funcs[st.name] = st
+
+		case "assert", "expect":
+			var holds bool
+			var got string
+			if holds, got, err = s.test(st); err != nil {
+				return false, err
+			}
+			if !holds {
+				fmt.Fprintf(s.out, "line %d: %s failed: %s%s\n", st.line, st.kind, st.text, got)
+				if st.kind == "assert" {
+					return false, scriptExit(1)
+				}
+				s.failures++
+			}
+
+		case "exit":
+			code, _ := strconv.Atoi(st.text)
+			return ok, scriptExit(code)
+
+		case "return":
+			return ok, errReturn
+
+		default:
+			ok, err = s.execute(st.text)
+		}
+
+		if err != nil {
+			return false, err
+		}
+	}
+	return ok, nil
+}
+
+// test evaluates the condition of the statement. For a single comparison
+// the value compared is also returned, as " (got value)". Syntax errors
+// stop the script with exit code 2.
+func (s *session) test(st statement) (bool, string, error) {
+	words, err := splitCondition(st.text)
+	if err != nil {
+		fmt.Fprintf(s.out, "line %d: %v\n", st.line, err)
+		return false, "", scriptExit(2)
+	}
+
+	var connErr error
+	c, err := buildCondition(words, func(word string, left bool) (operand, error) {
+		v, err := s.operand(word)
+		if err != nil && connErr == nil {
+			if _, ok := err.(conditionError); !ok {
+				connErr = err
+			}
+		}
+		return operand{value: v}, err
+	})
+	if connErr != nil {
+		return false, "", connErr
+	}
+	if err != nil {
+		fmt.Fprintf(s.out, "line %d: %v\n", st.line, err)
+		return false, "", scriptExit(2)
+	}
+
+	got := ""
+	if len(c.any) == 1 && len(c.any[0]) == 1 && c.any[0][0].op != "" {
+		got = " (got " + summary(c.any[0][0].left.value) + ")"
+	}
+	return c.eval(nil), got, nil
+}
+
+// splitCondition splits the condition into words like splitWords, except
+// that commands in parentheses and the path following them are kept as
+// one word.
+func splitCondition(expr string) ([]string, error) {
+	var words []string
+	for {
+		expr = strings.TrimLeftFunc(expr, isSpace)
+		if expr == "" {
+			return words, nil
+		}
+
+		if expr[0] == '(' {
+			end := closingParen(expr)
+			if end < 0 {
+				return nil, fmt.Errorf("missing ) after %s", expr)
+			}
+			end += 1 + strings.IndexFunc(expr[end+1:]+" ", isSpace)
+			words = append(words, expr[:end])
+			expr = expr[end:]
+			continue
+		}
+
+		var splitter wordOrJSONScanner
+		adv, word, err := splitter.split([]byte(expr), true)
+		if err != nil {
+			return nil, err
+		}
+		words = append(words, string(word))
+		expr = expr[adv:]
+	}
+}
+
+// A conditionError is a problem with an operand of a condition, as opposed
+// to a failure of the connection to PSM.
+type conditionError struct {
+	error
+}
+
+// operand returns the value of a word in a script condition; the result
+// of a command in parentheses, optionally followed by a path, a variable
+// reference or a literal.
+func (s *session) operand(word string) (interface{}, error) {
+	if strings.HasPrefix(word, "(") {
+		end := closingParen(word)
+		if end < 0 {
+			return nil, conditionError{fmt.Errorf("missing ) in %s", word)}
+		}
+		v, ok, err := s.evaluate(word[1:end])
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			// A failed command has no value
+			return nil, nil
+		}
+		if path := word[end+1:]; path != "" {
+			if !itemExp.MatchString(path) {
+				return nil, conditionError{fmt.Errorf("bad path %s", path)}
+			}
+			v, _ = walkPath(v, path, "")
+		}
+		return v, nil
+	}
+
+	if v, ok, err := lookupVar(word, s.vars); ok || err != nil {
+		if err != nil {
+			return nil, conditionError{err}
+		}
+		return v, nil
+	}
+
+	word, err := expandVars(word, s.vars)
+	if err != nil {
+		return nil, conditionError{err}
+	}
+	op, err := parseOperand(word, false)
+	if err != nil {
+		return nil, conditionError{err}
+	}
+	return op.value, nil
+}
+
+// call runs the function with the arguments in the command line, returning
+// whether its last command succeeded.
+func (s *session) call(f statement, args string) (bool, error) {
+	args, err := expandVars(args, s.vars)
+	if err != nil {
+		fmt.Fprintln(s.out, err)
+		return false, nil
+	}
+	words, err := splitWords(args)
+	if err != nil {
+		fmt.Fprintln(s.out, err)
+		return false, nil
+	}
+	if len(words) != len(f.params) {
+		fmt.Fprintf(s.out, "%s: expected %d arguments, got %d\n", f.name, len(f.params), len(words))
+		return false, nil
+	}
+
+	// The parameters are set as variables during the call, hiding any
+	// variables of the same name.
+
+	type saved struct {
+		v  interface{}
+		ok bool
+	}
+	prev := make(map[string]saved)
+	for i, name := range f.params {
+		op, err := parseOperand(words[i], false)
+		if err != nil {
+			fmt.Fprintln(s.out, err)
+			return false, nil
+		}
+		v, ok := s.vars[name]
+		prev[name] = saved{v, ok}
+		s.vars[name] = op.value
+	}
+	defer func() {
+		for name, p := range prev {
+			if p.ok {
+				s.vars[name] = p.v
+			} else {
+				delete(s.vars, name)
+			}
+		}
+	}()
+
+	ok, err := s.runBlock(f.body)
+	if err == errReturn {
+		err = nil
+	}
+	return ok, err
+}
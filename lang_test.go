@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseScriptErrors(t *testing.T) {
+	testcases := []string{
+		"if $x\nsystem hostname",
+		"end",
+		"else",
+		"if $x\nelse\nelse\nend",
+		"if\nend",
+		"func\nend",
+		"func f a-b\nend",
+		"func f\nsystem hostname",
+		"exit x",
+		"return 1",
+		"assert",
+		"if $x\nelse foo\nend",
+	}
+
+	for _, tc := range testcases {
+		var lines []scriptLine
+		for i, l := range strings.Split(tc, "\n") {
+			lines = append(lines, scriptLine{num: i + 1, text: l})
+		}
+		if _, err := parseScript(lines); err == nil {
+			t.Errorf("Missing error for %q", tc)
+		}
+	}
+}
+
+func TestRunScriptLanguage(t *testing.T) {
+	script := `
+func fixhost aid name
+  if (subscriber getByAid $aid).hostName == $name
+    return
+  end
+  object updateByAid subscriber $aid hostName=$name
+end
+
+set v = system version
+if $v ~ ^5
+  system old
+else if (system version) ~ ^6 and $v != ""
+  system six
+else
+  system other
+end
+
+fixhost 1 a
+fixhost 2 b
+expect (subscriber getByAid 2).hostName == b
+expect (subscriber getByAid 1).hostName == x
+assert $v == 6.1
+system last
+exit 3
+system never
+`
+
+	var methods []string
+	hosts := map[string]string{"1": "a", "2": "old"}
+	s, out := testSession(func(cmd command) (interface{}, int) {
+		methods = append(methods, cmd.Method)
+		switch cmd.Method {
+		case "system.version":
+			return "6.1", 0
+		case "subscriber.getByAid":
+			return map[string]interface{}{"hostName": hosts[cmd.Params[0].(string)]}, 0
+		case "object.updateByAid":
+			hosts[cmd.Params[1].(string)] = cmd.Params[2].(map[string]interface{})["hostName"].(string)
+		}
+		return nil, 0
+	})
+
+	code, err := s.runScript(strings.NewReader(script))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != 3 {
+		t.Errorf("Incorrect exit code %d\n%s", code, out)
+	}
+
+	exp := []string{
+		"system.version", "system.version", "system.six",
+		"subscriber.getByAid",
+		"subscriber.getByAid", "object.updateByAid",
+		"subscriber.getByAid", "subscriber.getByAid",
+		"system.last",
+	}
+	if !reflect.DeepEqual(methods, exp) {
+		t.Errorf("Incorrect methods:\n%q\n%q", methods, exp)
+	}
+	if s.failures != 1 || !strings.Contains(out.String(), `line 21: expect failed: (subscriber getByAid 1).hostName == x (got "a")`) {
+		t.Errorf("Incorrect expect failures %d\n%s", s.failures, out)
+	}
+	if _, ok := s.vars["aid"]; ok {
+		t.Error("Function parameter left set after call")
+	}
+}
+
+func TestRunScriptAssert(t *testing.T) {
+	s, out := testSession(func(cmd command) (interface{}, int) {
+		return json.Number("1"), 0
+	})
+	code, err := s.runScript(strings.NewReader("assert (system count) > 1\nsystem never\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != 1 || !strings.Contains(out.String(), "line 1: assert failed: (system count) > 1 (got 1)") {
+		t.Errorf("Incorrect result of failed assert %d\n%s", code, out)
+	}
+
+	code, _ = s.runScript(strings.NewReader("if (system count\nend\n"))
+	if code != 2 {
+		t.Errorf("Incorrect exit code %d for bad condition", code)
+	}
+}
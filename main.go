@@ -8,9 +8,11 @@ import (
 	"flag"
 	"fmt"
 	"io"
-	"net"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"golang.org/x/crypto/ssh/terminal"
 	"kastelo.io/psmcli/completion"
@@ -22,28 +24,69 @@ var (
 
 func main() {
 	verbose := flag.Bool("v", false, "Verbose output")
+	human := flag.Bool("human", false, "Show timestamps in local time with their relative age")
+	raw := flag.Bool("raw", false, "Show results exactly as sent by PSM")
+	themeSpec := flag.String("theme", defaultColorTheme, "JSON output colors, as token=color,... (empty for none)")
+	script := flag.String("f", "", "Run the commands in the script file (- for standard input) and exit")
+	loginUser := flag.String("user", "", "User name to log in as, if PSM requires authentication")
+	noInit := flag.Bool("noinit", false, "Don't run the startup scripts ~/.psmcli/init.psm and ~/.psmcli/<profile>/init.psm")
 	flag.Usage = usage
 	flag.Parse()
 	dst := flag.Arg(0)
 
+	switch dst {
+	case "snapshot":
+		os.Exit(snapshotMain(flag.Args()[1:], *loginUser, *verbose))
+	case "restore":
+		os.Exit(restoreMain(flag.Args()[1:], *loginUser, *verbose))
+	case "diff":
+		os.Exit(diffMain(flag.Args()[1:], *loginUser, *verbose))
+	case "plan", "apply":
+		os.Exit(planMain(dst, flag.Args()[1:], *loginUser, *verbose))
+	}
+
 	if dst == "" {
 		usage()
 		os.Exit(2)
 	}
 
-	fmt.Println("psmcli", Version)
-	fmt.Println("^D to quit")
+	// The destination may be the name of a profile from the config file
 
-	// Add default port 3994 if it's missing in the dst string
+	profiles, err := readProfiles(filepath.Join(configDir(), "config"))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	dst, prof, err := resolveDestination(dst, profiles)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	profileName := prof.name
+	if *loginUser == "" {
+		*loginUser = prof.user
+	}
 
-	host, port, err := net.SplitHostPort(dst)
-	if err != nil && strings.Contains(err.Error(), "missing port") {
-		dst = net.JoinHostPort(dst, "3994")
-	} else if err != nil {
+	theme, err := parseColorTheme(*themeSpec)
+	if err != nil {
 		fmt.Println(err)
-		return
-	} else if port == "" {
-		dst = net.JoinHostPort(host, "3994")
+		os.Exit(2)
+	}
+
+	format := formatJSON
+	switch {
+	case *human && *raw:
+		fmt.Println("Use only one of -human and -raw")
+		os.Exit(2)
+	case *human:
+		format = formatHuman
+	case *raw:
+		format = formatRaw
+	}
+
+	if *script == "" {
+		fmt.Println("psmcli", Version)
+		fmt.Println("^D to quit")
 	}
 
 	// Connect to PSM
@@ -51,10 +94,12 @@ func main() {
 	conn, err := newConnection(dst)
 	if err != nil {
 		fmt.Println(err)
-		return
+		os.Exit(1)
+	}
+	if *script == "" {
+		fmt.Println("Connected to", conn.conn.RemoteAddr())
+		fmt.Println("")
 	}
-	fmt.Println("Connected to", conn.conn.RemoteAddr())
-	fmt.Println("")
 
 	// Use system.version as dummy call to check if we can proceed without
 	// authentication.
@@ -62,7 +107,16 @@ func main() {
 	res, err := conn.run(command{Method: "system.version"})
 	if err != nil {
 		fmt.Println(err)
-		return
+		os.Exit(1)
+	}
+
+	if *script != "" {
+		renderer := jsonRenderer{theme: theme, format: format}
+		if useColor(os.Stdout.Fd()) {
+			renderer.esc = terminal.NewTerminal(os.Stdout, "").Escape
+		}
+		login := res.Error.Code == CodeAccessDenied
+		os.Exit(runScriptFile(conn, *script, login, *loginUser, profileName, *noInit, renderer, *verbose))
 	}
 
 	initialPrompt := "$ "
@@ -91,13 +145,41 @@ func main() {
 	}
 	term.SetSize(h, w)
 
+	// Parameters given as @- are read from the terminal in cooked mode, so
+	// that ^D ends the input.
+
+	readStdin = func() ([]byte, error) {
+		fmt.Fprintln(term, "Reading parameter from standard input, end with ^D")
+		var data []byte
+		err := withState(0, oldState, func() error {
+			var err error
+			data, err = ioutil.ReadAll(os.Stdin)
+			return err
+		})
+		return data, err
+	}
+
+	// Colour JSON output when writing to a terminal
+
+	renderer := jsonRenderer{theme: theme, format: format}
+	if useColor(os.Stdout.Fd()) {
+		renderer.esc = term.Escape
+	}
+
 	user := "default"
 	for res.Error.Code == CodeAccessDenied {
-		term.SetPrompt("Username: ")
-		user, err = term.ReadLine()
-		if err != nil {
-			fmt.Fprintln(term, err)
-			return
+		if *loginUser != "" {
+			// Given with -user or by the profile; asked for if the
+			// login fails.
+			user, *loginUser = *loginUser, ""
+			fmt.Fprintln(term, "Username:", user)
+		} else {
+			term.SetPrompt("Username: ")
+			user, err = term.ReadLine()
+			if err != nil {
+				fmt.Fprintln(term, err)
+				return
+			}
 		}
 		pass, err := term.ReadPassword("Password: ")
 		if err != nil {
@@ -156,7 +238,8 @@ func main() {
 
 	hostnameParts := strings.SplitN(hostname, ".", 2)
 	hostname = hostnameParts[0]
-	term.SetPrompt(user + "@" + hostname + roRw)
+	prompt := user + "@" + hostname + roRw
+	term.SetPrompt(prompt)
 
 	fmt.Fprintln(term)
 
@@ -172,53 +255,78 @@ func main() {
 	completer := completion.NewCallbackCompleter(matchers...)
 	term.AutoCompleteCallback = completer.Complete
 
-	// Start the REPL
+	// Long output is shown through a pager
 
-	id := 0
-	for {
-		line, err := term.ReadLine()
-		if err != nil {
-			return
-		}
+	pg := &pager{fd: 0, in: os.Stdin, out: term, cooked: oldState}
 
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
+	// Start the REPL
 
-		if line == "help" || line == "?" {
-			printHelp(term, term.Escape)
-			continue
-		}
-		if line == "commands" {
-			completer.PrintHelp(term, term.Escape)
-			continue
+	s := &session{
+		conn:      conn,
+		services:  smd.Result.Services,
+		completer: completer,
+		out:       term,
+		esc:       term.Escape,
+		pager:     pg,
+		cooked:    oldState,
+		renderer:  renderer,
+		verbose:   *verbose,
+		vars:      make(map[string]interface{}),
+	}
+	completer.Variables = s.varNames
+	completer.Commands = s.aliasMatchers
+
+	if err := s.loadConfig(filepath.Join(configDir(), "config")); err != nil {
+		fmt.Fprintln(term, err)
+	}
+	if !*noInit {
+		if err := s.runInit(profileName); err != nil {
+			fmt.Fprintln(term, err)
+			return
 		}
+	}
 
-		cmd, err := parseCommand(line)
+	for {
+		line, err := readCommand(term, prompt)
 		if err != nil {
+			return
+		}
+		if _, err := s.execute(line); err != nil {
 			fmt.Fprintln(term, err)
-			continue
+			return
 		}
+	}
+}
 
-		cmd.ID = id
-		id++
+// readCommand reads a command from the terminal. Commands ending with a
+// backslash, or within quotes or an unbalanced JSON object or array,
+// continue on the next line after a "... " prompt. Ending the input with ^D
+// during a continuation discards the command.
+func readCommand(term *terminal.Terminal, prompt string) (string, error) {
+	defer term.SetPrompt(prompt)
 
-		if *verbose {
-			// Print the command locally
-			bs, _ := json.Marshal(cmd)
-			fmt.Fprintf(term, "> %s\n", bs)
-		}
+	line, err := term.ReadLine()
+	if err != nil {
+		return "", err
+	}
 
-		// Execute command on PSM
+	for {
+		cont, ok := continues(line)
+		if !ok {
+			return line, nil
+		}
+		if cont == line {
+			// Within quotes or JSON the line break is kept
+			cont += "\n"
+		}
 
-		res, err := conn.run(cmd)
+		term.SetPrompt("... ")
+		next, err := term.ReadLine()
 		if err != nil {
-			fmt.Fprintln(term, err)
-			return
+			fmt.Fprintln(term, "Incomplete command discarded")
+			return "", nil
 		}
-
-		printResponse(term, res)
+		line = cont + next
 	}
 }
 
@@ -226,12 +334,58 @@ func usage() {
 	fmt.Println("psmcli", Version)
 	fmt.Println()
 	fmt.Println("Usage:")
-	fmt.Println("  psmcli [-v] <host:port>")
+	fmt.Println("  psmcli [-v] [-human | -raw] [-theme token=color,...] [-user name] [-noinit] <host:port | profile>")
+	fmt.Println("  psmcli [options] -f <script> <host:port | profile>")
+	fmt.Println("  psmcli [options] snapshot [-types=a,b] [-o file] <host:port | profile> > dump.psmsnap")
+	fmt.Println("  psmcli [options] restore [-policy=skip|overwrite|fail] [-dry-run] <host:port | profile> dump.psmsnap")
+	fmt.Println("  psmcli [options] diff [-json] [-types=a,b] <snapshot | host:port | profile> <snapshot | host:port | profile>")
+	fmt.Println("  psmcli [options] plan [-prune] <host:port | profile> <state.yaml>")
+	fmt.Println("  psmcli [options] apply [-prune] [-y] <host:port | profile> <state.yaml>")
+	fmt.Println()
+	fmt.Println("Output is colored when writing to a terminal, unless NO_COLOR is set.")
+	fmt.Println()
+	fmt.Println("Profiles are defined in ~/.psmcli/config as \"profile name host[:port] [user]\".")
+	fmt.Println("After logging in, the startup scripts ~/.psmcli/init.psm and")
+	fmt.Println("~/.psmcli/<profile>/init.psm are run, unless -noinit is given.")
+	fmt.Println()
+	fmt.Println("Scripts contain commands as entered in the REPL, one per line, and the")
+	fmt.Println("statements if/else if/else/end, func name params/return/end, assert,")
+	fmt.Println("expect and exit [code]. The exit code is that given to exit, or 1 if an")
+	fmt.Println("expect failed or the last command failed. When running a script the")
+	fmt.Println("password is taken from PSMCLI_PASSWORD, or read from the terminal.")
+	fmt.Println()
+	fmt.Println("A snapshot holds all objects of the types with a list method, and the")
+	fmt.Println("PSM version and hostname. Restoring it prints a preflight report of the")
+	fmt.Println("new and existing objects of each type, and then creates the new ones.")
+	fmt.Println("Existing objects, by application ID, are skipped, overwritten or, by")
+	fmt.Println("default, stop the restore before anything is changed.")
+	fmt.Println()
+	fmt.Println("Diff compares two snapshots, or a snapshot and a live node, by object")
+	fmt.Println("type and application ID rather than OID, and prints the added, removed")
+	fmt.Println("and changed objects with the changed attributes. The exit code is 0 if")
+	fmt.Println("there are no differences, 1 if there are and 2 on errors.")
+	fmt.Println()
+	fmt.Println("A desired state file, in YAML or JSON, maps object types to lists of")
+	fmt.Println("objects with their application IDs and the attributes to manage. Plan")
+	fmt.Println("prints the changes needed for the node to match it, and apply makes them")
+	fmt.Println("after confirmation and reads the objects back to verify. Objects of the")
+	fmt.Println("types in the file that aren't in it are deleted only with -prune.")
+	fmt.Println("A parentOid is given as the parent's type and application ID, e.g.")
+	fmt.Println("\"group g1\"; parents are created before their children.")
 }
 
-func printResponse(out io.Writer, res response) {
+func printResponse(out io.Writer, res response, r jsonRenderer) {
+	if r.format == formatHuman {
+		res.Result = humanize(res.Result, time.Now())
+	}
+
 	if res.Error.Code != 0 {
 		fmt.Fprintf(out, "Error %d: %s\n", res.Error.Code, res.Error.Message)
+	} else if r.format == formatRaw {
+		if len(res.RawResult) > 0 {
+			r.write(out, res.RawResult)
+			fmt.Fprintln(out)
+		}
 	} else if res.Result != nil {
 		switch result := res.Result.(type) {
 		case []interface{}:
@@ -240,14 +394,14 @@ func printResponse(out io.Writer, res response) {
 				case string, float64, int, json.Number:
 					fmt.Fprintln(out, res)
 				default:
-					bs, _ := json.MarshalIndent(res, "", "    ")
-					fmt.Fprintf(out, "%s\n\n", bs)
+					r.render(out, res)
+					fmt.Fprintln(out)
 				}
 			}
 
 		case map[string]interface{}:
-			bs, _ := json.MarshalIndent(result, "", "    ")
-			fmt.Fprintf(out, "%s\n\n", bs)
+			r.render(out, result)
+			fmt.Fprintln(out)
 
 		default:
 			fmt.Fprintln(out, result)
@@ -264,6 +418,33 @@ help, ?:
 commands:
 	Print available PSM commands. Commands have tab completion available.
 
+format [json|human|raw]:
+	Show or set the output format. The human format shows timestamps in
+	local time with their relative age. The raw format shows results
+	exactly as sent by PSM.
+
+set [name = command]:
+	Run the command and keep its result in the variable instead of
+	printing it, or without arguments list the variables. The result of
+	the last successful command is always kept in $_.
+
+alias [name = command]:
+	Define a name for the start of a command, or without arguments list
+	the aliases and macros.
+
+macro name $param ... = command:
+	Define a command with parameters, which are replaced by the arguments
+	wherever they occur in the command.
+
+unalias name:
+	Remove an alias or macro.
+
+Aliases and macros can also be defined in ~/.psmcli/config, one per line.
+
+Output longer than the screen is shown in a pager; space for the next page,
+b for the previous, / to search and q to quit. Set PAGER to use an external
+pager instead.
+
 Examples:
 
 Simple command without parameter:
@@ -276,13 +457,106 @@ Command with object parameters:
 	$ object updateByAid subscriber 1234 attr=value
 	$ object updateByAid subscriber 1234 attr1=value1,attr2=value2
 
-	(No spaces in the object parameter!)
+	Use quotes or backslash escapes for spaces, commas and equal signs:
+	$ object updateByAid subscriber 1234 hostName="my host",note='a,b'
+	$ object updateByAid subscriber 1234 hostName=my\ host
 
 Command with arbitrary JSON object parameter:
-	$ object updateByAid subscriber 1234
-		{"attr1": "value1 with space", "attr2": "value2"}
-
-	(Line break for display purposes only)
+	$ object updateByAid subscriber 1234 {"attr1": "value1 with space",
+	... "attr2": "value2"}
+
+	(Commands continue on the next line after a "..." prompt while within
+	quotes or a JSON object or array, or after a trailing backslash)
+
+Command with JSON array, literal and typed parameters:
+	$ object method [1,2,3] null true false
+	$ object method int:42 float:1.5 bool:true str:42 json:{"a":[1]}
+
+	(Quote "null", "true" and "false" to send them as strings)
+
+Command with nested object and list parameters:
+	$ object updateByAid subscriber 1234 a.b=1,tags=[x,y]
+
+Command sent as given, without checking it against the announced methods
+and parameter types first:
+	$ ! object someMethod 1234
+
+Raw JSON-RPC request, with the response printed exactly as received:
+	$ raw {"method": "system.hostname", "params": []}
+	$ {"method": "system.hostname", "params": []}
+
+	(An "id" is added if missing)
+
+Command with named parameters, in any order:
+	$ object updateByAid --aid=1234 --type=subscriber --attributes=hostName=x
+
+Command with parameters read from JSON or YAML files, or standard input:
+	$ object updateByAid subscriber 1234 @attrs.json
+	$ object updateByAid subscriber 1234 @attrs.yaml
+	$ object updateByAid subscriber 1234 @-
+
+Command using a result kept in a variable, or part of one:
+	$ set sub = subscriber getByAid 1234
+	$ object updateByOid $sub.oid note=x
+	$ object updateByOid $_[0].oid note=${other.note}
+	$ set oid = $sub.oid
+
+	(Variables are not replaced within single quotes or after a backslash)
+
+Commands chained on one line; the command after && runs only if the
+previous one succeeded, the one after || only if it failed:
+	$ object updateByAid subscriber 1234 hostName=a && subscriber getByAid 1234
+	$ subscriber getByAid 1234 || object create subscriber subscriberId=1234
+	$ system hostname; system version
+
+Result lists filtered by a condition on the elements; ==, !=, <, <=, >, >=,
+~ (regular expression match) and !~ compare, "and", "or" and "not" combine:
+	$ subscriber list 1000 | where persistent == true and hostName ~ ^web
+	$ set subs = subscriber list 1000 | where not persistent
+
+Command run for each element of a list, stopping after 100 (or --max=N,
+zero for no limit) calls that change something:
+	$ foreach x in (subscriber list 1000 | where persistent) do \
+	... object updateByAid subscriber $x.subscriberId persistent=false
+	$ foreach --max=5000 x in $subs do object deleteByAid subscriber $x.subscriberId
+
+Objects created or updated from the rows of a CSV file, with attribute
+names in the header row, a file of one JSON object per line (.jsonl) or a
+JSON array (.json).
+Rows whose application ID is on the node are updated and others created;
+--mode=create or --mode=update does only one or the other. Failed rows
+are written to file.errors.csv, or --errors=file, ready to fix and import
+again:
+	$ import --dry-run subscriber subs.csv
+	$ import --concurrency=8 subscriber subs.csv
+	$ import --mode=create --errors=failed.jsonl subscriber subs.jsonl
+
+All objects of a type written to a file in the same formats, listed 1000
+(or --page=N) at a time, optionally with only some attributes. CSV
+columns are those of the first 100 objects; give --fields if later ones
+have other attributes:
+	$ export subscriber subs.csv
+	$ export --fields=subscriberId,hostName subscriber subs.jsonl
+
+Objects copied to the node of another profile, one by application ID, all
+of a type or those matching a condition, after a report of the new and
+existing objects. Existing objects stop the copy unless --policy=skip or
+--policy=overwrite is given; parents not copied must exist on the target.
+--user=name logs in to the target as another user than the profile's:
+	$ copy --dry-run subscriber 1234 --to lab
+	$ copy --user=admin subscriber where slot > 5 --to lab
+	$ copy --policy=skip subscriber where hostName ~ ^web --to lab
+
+Commands using aliases and macros:
+	$ alias sub = subscriber getByAid
+	$ sub 1234
+	$ macro fixhost $aid $name = object updateByAid subscriber $aid hostName=$name
+	$ fixhost 1234 "my host"
+
+Output redirected to a file or shell pipeline:
+	$ subscriber list 5000 > subs.json
+	$ subscriber list 5000 >> subs.json
+	$ subscriber list 5000 | grep syno
 
 `)
 }
@@ -0,0 +1,78 @@
+// psmcli
+// Copyright (C) 2014 Procera Networks, Inc.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// resolveNamed puts parameters given as --name=value in their positions
+// according to the method's parameter list in the SMD. Skipped optional
+// parameters before the last given one are sent as null.
+func resolveNamed(cmd *command, services map[string]smdService) error {
+	var named []namedParam
+	var positional []interface{}
+	for _, p := range cmd.Params {
+		if n, ok := p.(namedParam); ok {
+			named = append(named, n)
+		} else {
+			positional = append(positional, p)
+		}
+	}
+	if len(named) == 0 {
+		return nil
+	}
+
+	svc, ok := services[cmd.Method]
+	if !ok {
+		return fmt.Errorf("named parameters can't be used with unknown method %s", cmd.Method)
+	}
+
+	params := make([]interface{}, len(svc.Parameters))
+	given := make([]bool, len(svc.Parameters))
+	last := len(positional) - 1
+	if len(positional) > len(params) {
+		return fmt.Errorf("too many parameters for %s", cmd.Method)
+	}
+	for i, p := range positional {
+		params[i], given[i] = p, true
+	}
+
+	for _, n := range named {
+		i := paramIndex(svc, n.Name)
+		if i < 0 {
+			var names []string
+			for _, p := range svc.Parameters {
+				names = append(names, p.Name)
+			}
+			return fmt.Errorf("unknown parameter %s for %s; expected one of %s", n.Name, cmd.Method, strings.Join(names, ", "))
+		}
+		if given[i] {
+			return fmt.Errorf("parameter %s given more than once", n.Name)
+		}
+		params[i], given[i] = n.Value, true
+		if i > last {
+			last = i
+		}
+	}
+
+	for i, p := range svc.Parameters[:last+1] {
+		if !given[i] && !p.Optional {
+			return fmt.Errorf("missing required parameter %s", p.Name)
+		}
+	}
+
+	cmd.Params = params[:last+1]
+	return nil
+}
+
+func paramIndex(svc smdService, name string) int {
+	for i, p := range svc.Parameters {
+		if p.Name == name {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResolveNamed(t *testing.T) {
+	services := map[string]smdService{
+		"object.updateByAid": {
+			Parameters: []smdParameter{
+				{Name: "type", Type: "string"},
+				{Name: "aid", Type: "string"},
+				{Name: "attributes", Type: "object"},
+				{Name: "create", Type: "boolean", Optional: true},
+				{Name: "ttl", Type: "integer", Optional: true},
+			},
+		},
+	}
+
+	testcases := []struct {
+		line   string
+		params []interface{}
+	}{
+		{
+			"object updateByAid subscriber 1234 a=b",
+			[]interface{}{"subscriber", "1234", attributes{"a": "b"}},
+		},
+		{
+			"object updateByAid --attributes=hostName=x --aid:20:c9:d0:43:0c:95 --type=subscriber",
+			[]interface{}{"subscriber", "20:c9:d0:43:0c:95", attributes{"hostName": "x"}},
+		},
+		{
+			"object updateByAid subscriber --attributes={} --aid=1234",
+			[]interface{}{"subscriber", "1234", map[string]interface{}{}},
+		},
+		{
+			"object updateByAid subscriber 1234 a=b --ttl=60",
+			[]interface{}{"subscriber", "1234", attributes{"a": "b"}, nil, "60"},
+		},
+	}
+
+	for _, tc := range testcases {
+		cmd, err := parseCommand(tc.line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := resolveNamed(&cmd, services); err != nil {
+			t.Errorf("Unexpected error for %q: %v", tc.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(cmd.Params, tc.params) {
+			t.Errorf("Incorrect parameters for %q:\n\t%#v\n\t%#v", tc.line, cmd.Params, tc.params)
+		}
+	}
+
+	for _, line := range []string{
+		"object updateByAid --foo=bar",
+		"object updateByAid subscriber --type=subscriber",
+		"object updateByAid subscriber --attributes=a=b",
+		"object unknown --type=subscriber",
+	} {
+		cmd, err := parseCommand(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := resolveNamed(&cmd, services); err == nil {
+			t.Errorf("Unexpected nil error for %q", line)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+// psmcli
+// Copyright (C) 2014 Procera Networks, Inc.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The generic object methods, taking the object type as first parameter.
+const (
+	methodCreate      = "object.create"
+	methodUpdateByAid = "object.updateByAid"
+	methodDeleteByAid = "object.deleteByAid"
+)
+
+// aidAttributes are the attributes holding the application ID of object
+// types where it isn't called "aid".
+var aidAttributes = map[string]string{
+	"subscriber": "subscriberId",
+}
+
+// serverFields are the attributes managed by PSM, which are left out when
+// creating objects.
+var serverFields = []string{"oid", "creationTime", "updateTime"}
+
+// aidAttribute returns the name of the attribute holding the application ID
+// of objects of the type.
+func aidAttribute(typ string) string {
+	if a, ok := aidAttributes[typ]; ok {
+		return a
+	}
+	return "aid"
+}
+
+// objectCommand returns the command calling the method, with the parameters
+// converted and checked against the SMD.
+func objectCommand(services map[string]smdService, method string, params ...interface{}) (command, error) {
+	cmd := command{Method: method, Params: params}
+	if err := coerceParams(&cmd, services, objectAttributeTypes); err != nil {
+		return cmd, err
+	}
+	return cmd, validateCommand(cmd, services)
+}
+
+// createdOid returns the OID of the new object from the result of
+// object.create, if the result is one.
+func createdOid(result interface{}) (interface{}, bool) {
+	if n, ok := result.(json.Number); ok {
+		if _, err := n.Int64(); err == nil {
+			return n, true
+		}
+	}
+	return nil, false
+}
+
+// objectAid returns the application ID of the object of the type, or "".
+func objectAid(typ string, obj map[string]interface{}) string {
+	v, ok := obj[aidAttribute(typ)]
+	if !ok || v == nil {
+		return ""
+	}
+	return plainString(v)
+}
+
+// defaultPageSize is the number of objects requested at a time when
+// listing all objects of a type.
+const defaultPageSize = 1000
+
+// attributeSample is the number of objects listed to learn the types of
+// the attributes of a type.
+const attributeSample = 10
+
+// listMethod returns the method listing objects of the type.
+func listMethod(typ string) string {
+	return typ + ".list"
+}
+
+// listCommand returns the command listing a page of objects of the type,
+// starting at the offset. The page size and offset parameters are found by
+// name in the SMD; if the method isn't described they're assumed to be
+// the first two. Paged is false if the method can't list from an offset,
+// and sized is false if it can't limit the number of objects.
+func listCommand(typ string, services map[string]smdService, size, offset int) (cmd command, paged, sized bool) {
+	cmd.Method = listMethod(typ)
+	svc, ok := services[cmd.Method]
+	if !ok {
+		cmd.Params = []interface{}{json.Number(strconv.Itoa(size)), json.Number(strconv.Itoa(offset))}
+		return cmd, true, true
+	}
+
+	for _, p := range svc.Parameters {
+		switch pageParam(p.Name) {
+		case "offset":
+			cmd.Params = append(cmd.Params, json.Number(strconv.Itoa(offset)))
+			paged = true
+		case "size":
+			cmd.Params = append(cmd.Params, json.Number(strconv.Itoa(size)))
+			sized = true
+		default:
+			return cmd, paged, sized
+		}
+	}
+	return cmd, paged, sized
+}
+
+// pageParam returns "offset" or "size" if the named list method parameter
+// is the offset or page size, and otherwise "".
+func pageParam(name string) string {
+	switch strings.ToLower(name) {
+	case "offset", "start", "skip":
+		return "offset"
+	case "limit", "count", "max", "maxcount", "size", "pagesize":
+		return "size"
+	}
+	return ""
+}
+
+// attributeTypes returns the JSON types of the attributes of objects of
+// the type, from the first objects on the node. Only objectAttributeTypes
+// are known for types without objects or that can't be listed a few at a
+// time. The types are kept for the session.
+func (s *session) attributeTypes(typ string) (map[string]string, error) {
+	if types, ok := s.attrTypes[typ]; ok {
+		return types, nil
+	}
+
+	var objs []map[string]interface{}
+	cmd, _, sized := listCommand(typ, s.services, attributeSample, 0)
+	if _, ok := s.services[cmd.Method]; sized && (ok || len(s.services) == 0) {
+		cmd.ID = s.id
+		s.id++
+		res, err := s.conn.run(cmd)
+		if err != nil {
+			return nil, err
+		}
+		list, _ := res.Result.([]interface{})
+		for _, o := range list {
+			if obj, ok := o.(map[string]interface{}); ok {
+				objs = append(objs, obj)
+			}
+		}
+	}
+
+	types := valueTypes(objs)
+	if s.attrTypes == nil {
+		s.attrTypes = make(map[string]map[string]string)
+	}
+	s.attrTypes[typ] = types
+	return types, nil
+}
+
+// commandAttributeTypes returns the attribute types for key=val
+// parameters of the command, which refers to the object type by its first
+// parameter for object methods and by the method name otherwise.
+func (s *session) commandAttributeTypes(cmd command) (map[string]string, error) {
+	if _, ok := s.services[cmd.Method]; !ok {
+		return objectAttributeTypes, nil
+	}
+	hasAttrs := false
+	for _, p := range cmd.Params {
+		if _, ok := p.(attributes); ok {
+			hasAttrs = true
+		}
+	}
+	if !hasAttrs {
+		return objectAttributeTypes, nil
+	}
+
+	typ := strings.SplitN(cmd.Method, ".", 2)[0]
+	if typ == "object" {
+		if len(cmd.Params) == 0 {
+			return objectAttributeTypes, nil
+		}
+		name, ok := cmd.Params[0].(string)
+		if !ok {
+			return objectAttributeTypes, nil
+		}
+		typ = name
+	}
+	return s.attributeTypes(typ)
+}
+
+// objectTypes returns the object types with a list method in the SMD that
+// can be called without other parameters than the page size and offset.
+func objectTypes(services map[string]smdService) []string {
+	var types []string
+	for name, svc := range services {
+		if !strings.HasSuffix(name, ".list") {
+			continue
+		}
+		ok := true
+		for _, p := range svc.Parameters {
+			if !p.Optional && pageParam(p.Name) == "" {
+				ok = false
+			}
+		}
+		if typ := strings.TrimSuffix(name, ".list"); ok && typ != "object" && typ != "system" {
+			types = append(types, typ)
+		}
+	}
+	sort.Strings(types)
+	return types
+}
+
+// listObjects calls fn for each object of the type, listing them a page at
+// a time. Methods that can't list from an offset are called again with a
+// doubled count until they return fewer objects than asked for. PSM
+// errors, and those returned by fn, are printed and stop the listing.
+func (s *session) listObjects(typ string, pageSize int, fn func(obj map[string]interface{}) error) (bool, error) {
+	for offset, size := 0, pageSize; ; {
+		cmd, paged, sized := listCommand(typ, s.services, size, offset)
+		cmd.ID = s.id
+		s.id++
+		if s.verbose {
+			bs, _ := json.Marshal(cmd)
+			fmt.Fprintf(s.out, "> %s\n", bs)
+		}
+
+		res, err := s.conn.run(cmd)
+		if err != nil {
+			return false, err
+		}
+		if res.Error.Code != 0 {
+			printResponse(s.out, res, s.renderer)
+			return false, nil
+		}
+
+		if res.Result == nil {
+			return true, nil
+		}
+		objs, ok := res.Result.([]interface{})
+		if !ok {
+			fmt.Fprintln(s.out, cmd.Method, "didn't return a list")
+			return false, nil
+		}
+		if !paged && sized && len(objs) >= size {
+			// There may be more; ask for all of them again
+			size *= 2
+			continue
+		}
+		for _, o := range objs {
+			obj, ok := o.(map[string]interface{})
+			if !ok {
+				fmt.Fprintln(s.out, cmd.Method, "didn't return a list of objects")
+				return false, nil
+			}
+			if err := fn(obj); err != nil {
+				fmt.Fprintln(s.out, err)
+				return false, nil
+			}
+		}
+
+		if !paged || len(objs) < size {
+			return true, nil
+		}
+		offset += len(objs)
+	}
+}
@@ -0,0 +1,292 @@
+// psmcli
+// Copyright (C) 2014 Procera Networks, Inc.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// A pager shows output that is too long to fit on the screen one page at a
+// time, either using the program in $PAGER or an internal, less like, pager.
+// Output that fits on the screen is written directly to out.
+type pager struct {
+	fd     int             // terminal file descriptor, for size and mode changes
+	in     io.Reader       // keyboard input, in raw mode
+	out    io.Writer       // where to write output
+	cooked *terminal.State // the terminal state to use while running external pagers
+}
+
+// show writes the given output, through a pager if necessary.
+func (p *pager) show(bs []byte) error {
+	width, height, err := terminal.GetSize(p.fd)
+	if err != nil || height < 2 {
+		_, err := p.out.Write(bs)
+		return err
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(bs), "\n"), "\n")
+	if displayRows(lines, width) < height {
+		_, err := p.out.Write(bs)
+		return err
+	}
+
+	if cmd := os.Getenv("PAGER"); cmd != "" {
+		return p.external(cmd, bs)
+	}
+	return p.internal(lines, width, height)
+}
+
+// external runs the given pager command with the terminal temporarily in
+// cooked mode.
+func (p *pager) external(command string, bs []byte) error {
+	return withState(p.fd, p.cooked, func() error {
+		cmd := exec.Command("/bin/sh", "-c", command)
+		cmd.Stdin = bytes.NewReader(bs)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		cmd.Env = os.Environ()
+		if os.Getenv("LESS") == "" {
+			// Like git, make less exit on short output and pass colors through.
+			cmd.Env = append(cmd.Env, "LESS=FRX")
+		}
+		return cmd.Run()
+	})
+}
+
+// withState calls fn with the terminal temporarily set to the given state,
+// restoring the current state afterwards. A nil state calls fn directly.
+func withState(fd int, state *terminal.State, fn func() error) error {
+	if state == nil {
+		return fn()
+	}
+
+	cur, err := terminal.GetState(fd)
+	if err != nil {
+		return err
+	}
+	if err := terminal.Restore(fd, state); err != nil {
+		return err
+	}
+	defer terminal.Restore(fd, cur)
+
+	return fn()
+}
+
+// internal pages through the lines on the alternate screen until the user
+// quits. Lines longer than the width are wrapped into several screen rows,
+// which are paged as lines of their own.
+func (p *pager) internal(lines []string, width, height int) error {
+	lines = wrapRows(lines, width)
+	rows := height - 1 // the last row is the status line
+	top := 0
+	search := ""
+	status := ""
+
+	// Switch to the alternate screen, and back again when we're done, so
+	// that the REPL history is left intact.
+	fmt.Fprint(p.out, "\x1b[?1049h")
+	defer fmt.Fprint(p.out, "\x1b[?1049l")
+
+	lastTop := func() int {
+		// The top line that shows the end of the output on the last page.
+		n := 0
+		for i := len(lines) - 1; i >= 0; i-- {
+			n += displayRows(lines[i:i+1], width)
+			if n > rows {
+				return i + 1
+			}
+		}
+		return 0
+	}
+
+	find := func(from int) {
+		for i := from; i < len(lines); i++ {
+			if strings.Contains(stripEscapes(lines[i]), search) {
+				top = i
+				return
+			}
+		}
+		status = "Pattern not found"
+	}
+
+	buf := make([]byte, 16)
+	for {
+		if max := lastTop(); top > max {
+			top = max
+		}
+		if top < 0 {
+			top = 0
+		}
+
+		// Draw the page
+		fmt.Fprint(p.out, "\x1b[H\x1b[2J")
+		n, i := 0, top
+		for ; i < len(lines); i++ {
+			r := displayRows(lines[i:i+1], width)
+			if n+r > rows {
+				break
+			}
+			fmt.Fprint(p.out, lines[i], "\r\n")
+			n += r
+		}
+		if status == "" {
+			if i >= len(lines) {
+				status = "(END)"
+			} else {
+				status = fmt.Sprintf("lines %d-%d of %d (%d%%)", top+1, i, len(lines), 100*i/len(lines))
+			}
+		}
+		fmt.Fprint(p.out, "\x1b[", height, ";1H\x1b[7m", status, "\x1b[0m")
+		status = ""
+
+		n, err := p.in.Read(buf)
+		if err != nil {
+			return err
+		}
+		switch key := string(buf[:n]); key {
+		case "q", "Q", "\x03", "\x04":
+			return nil
+		case " ", "f", "\x1b[6~":
+			top = i
+		case "b", "\x1b[5~":
+			top -= rows
+		case "\r", "\n", "j", "\x1b[B":
+			top++
+		case "k", "\x1b[A":
+			top--
+		case "g", "<", "\x1b[H":
+			top = 0
+		case "G", ">", "\x1b[F":
+			top = len(lines)
+		case "/":
+			fmt.Fprint(p.out, "\x1b[", height, ";1H\x1b[K/")
+			s, ok := p.readPattern()
+			if ok && s != "" {
+				search = s
+				find(top + 1)
+			}
+		case "n":
+			if search != "" {
+				find(top + 1)
+			}
+		default:
+			status = "q:quit space:next page b:previous page /:search n:next match"
+		}
+	}
+}
+
+// readPattern reads a line of input with echo, returning false if the user
+// cancels it by pressing escape or ^C.
+func (p *pager) readPattern() (string, bool) {
+	var pattern []byte
+	buf := make([]byte, 16)
+	for {
+		n, err := p.in.Read(buf)
+		if err != nil {
+			return "", false
+		}
+		for _, c := range buf[:n] {
+			switch c {
+			case '\r', '\n':
+				return string(pattern), true
+			case '\x1b', '\x03':
+				return "", false
+			case '\x7f', '\b':
+				if len(pattern) > 0 {
+					_, size := utf8.DecodeLastRune(pattern)
+					pattern = pattern[:len(pattern)-size]
+					fmt.Fprint(p.out, "\b \b")
+				}
+			default:
+				if c >= ' ' {
+					pattern = append(pattern, c)
+					p.out.Write([]byte{c})
+				}
+			}
+		}
+	}
+}
+
+// wrapRows splits lines longer than the width into lines of at most width
+// characters, not counting color escape sequences.
+func wrapRows(lines []string, width int) []string {
+	if width <= 0 {
+		return lines
+	}
+	var res []string
+	for _, line := range lines {
+		if displayRows([]string{line}, width) <= 1 {
+			res = append(res, line)
+			continue
+		}
+		var row []rune
+		n := 0
+		inEscape := false
+		for _, r := range line {
+			switch {
+			case inEscape:
+				if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' {
+					inEscape = false
+				}
+			case r == '\x1b':
+				inEscape = true
+			default:
+				if n == width {
+					res = append(res, string(row))
+					row, n = nil, 0
+				}
+				n++
+			}
+			row = append(row, r)
+		}
+		res = append(res, string(row))
+	}
+	return res
+}
+
+// displayRows returns the number of terminal rows needed to show the lines,
+// taking wrapping of long lines into account.
+func displayRows(lines []string, width int) int {
+	n := 0
+	for _, line := range lines {
+		l := utf8.RuneCountInString(stripEscapes(line))
+		if width <= 0 || l <= width {
+			n++
+		} else {
+			n += (l + width - 1) / width
+		}
+	}
+	return n
+}
+
+// stripEscapes returns the string with terminal color escape sequences
+// removed.
+func stripEscapes(s string) string {
+	if !strings.Contains(s, "\x1b") {
+		return s
+	}
+	var res []rune
+	inEscape := false
+	for _, r := range s {
+		switch {
+		case inEscape:
+			if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' {
+				inEscape = false
+			}
+		case r == '\x1b':
+			inEscape = true
+		default:
+			res = append(res, r)
+		}
+	}
+	return string(res)
+}
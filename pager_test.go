@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDisplayRows(t *testing.T) {
+	testcases := []struct {
+		lines []string
+		width int
+		rows  int
+	}{
+		{[]string{"foo", "bar"}, 10, 2},
+		{[]string{"0123456789"}, 10, 1},
+		{[]string{"0123456789a"}, 10, 2},
+		{[]string{"\x1b[32m0123456789\x1b[0m"}, 10, 1},
+		{[]string{"åäö"}, 3, 1},
+	}
+
+	for _, tc := range testcases {
+		if rows := displayRows(tc.lines, tc.width); rows != tc.rows {
+			t.Errorf("Incorrect row count for %q: %d != %d", tc.lines, rows, tc.rows)
+		}
+	}
+}
+
+func TestInternalPager(t *testing.T) {
+	var lines []string
+	for i := 0; i < 100; i++ {
+		lines = append(lines, fmt.Sprintf("line %d", i))
+	}
+
+	// Search for line 42, which moves it to the top of the page, then quit.
+	var out bytes.Buffer
+	p := &pager{in: &oneByOne{[]string{"/", "line 42\r", "q"}}, out: &out}
+	if err := p.internal(lines, 80, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	pages := strings.Split(out.String(), "\x1b[H\x1b[2J")
+	last := pages[len(pages)-1]
+	if !strings.HasPrefix(last, "line 42\r\n") {
+		t.Errorf("Incorrect last page after search: %q", last)
+	}
+	if !strings.Contains(last, "lines 43-51 of 100") {
+		t.Errorf("Incorrect status line on last page: %q", last)
+	}
+}
+
+func TestWrapRows(t *testing.T) {
+	testcases := []struct {
+		lines []string
+		rows  []string
+	}{
+		{[]string{"foo", "0123456789"}, []string{"foo", "0123456789"}},
+		{[]string{"0123456789abcdefghijk"}, []string{"0123456789", "abcdefghij", "k"}},
+		{[]string{"\x1b[32m0123456789ab\x1b[0m"}, []string{"\x1b[32m0123456789", "ab\x1b[0m"}},
+	}
+
+	for _, tc := range testcases {
+		if rows := wrapRows(tc.lines, 10); !reflect.DeepEqual(rows, tc.rows) {
+			t.Errorf("Incorrect rows for %q: %q != %q", tc.lines, rows, tc.rows)
+		}
+	}
+}
+
+func TestInternalPagerLongLine(t *testing.T) {
+	// A single line longer than the screen, as from format raw, is shown
+	// a page of rows at a time
+	line := strings.Repeat("x", 80*20)
+	var out bytes.Buffer
+	p := &pager{in: &oneByOne{[]string{" ", "q"}}, out: &out}
+	if err := p.internal([]string{line}, 80, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	pages := strings.Split(out.String(), "\x1b[H\x1b[2J")
+	if len(pages) != 3 {
+		t.Fatalf("Incorrect number of pages %d != expected 2", len(pages)-1)
+	}
+	for i, exp := range []string{"lines 1-9 of 20", "lines 10-18 of 20"} {
+		if strings.Count(pages[i+1], strings.Repeat("x", 80)+"\r\n") != 9 || !strings.Contains(pages[i+1], exp) {
+			t.Errorf("Incorrect page %d: %q", i+1, pages[i+1])
+		}
+	}
+}
+
+// oneByOne returns one of its strings for each call to Read, like keys
+// pressed one at a time on a terminal.
+type oneByOne struct {
+	reads []string
+}
+
+func (r *oneByOne) Read(bs []byte) (int, error) {
+	if len(r.reads) == 0 {
+		return 0, fmt.Errorf("out of input")
+	}
+	n := copy(bs, r.reads[0])
+	r.reads = r.reads[1:]
+	return n, nil
+}
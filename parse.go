@@ -5,50 +5,146 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
 	"strings"
 	"unicode/utf8"
 )
 
 type wordOrJSONScanner struct {
-	inJSON bool
+	offset int  // bytes of the line consumed so far
+	open   bool // the line ended within quotes or unbalanced JSON
 }
 
 func (s *wordOrJSONScanner) split(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	// Skip leading spaces.
 	start := 0
-	if !s.inJSON {
-		// Skip leading spaces.
-		for width := 0; start < len(data); start += width {
-			var r rune
-			r, width = utf8.DecodeRune(data[start:])
-			if !isSpace(r) {
-				break
-			}
+	for width := 0; start < len(data); start += width {
+		var r rune
+		r, width = utf8.DecodeRune(data[start:])
+		if !isSpace(r) {
+			break
 		}
 	}
 
-	// Scan until space, marking end of word.
+	// Scan until space, marking end of word. Spaces within quotes or after
+	// a backslash don't end the word, except in words starting with "("
+	// which are LDAP filters where quotes and backslashes have no special
+	// meaning. A word starting with { or [ is a JSON object or array, which
+	// continues until the brackets balance.
+	literal := start < len(data) && data[start] == '('
+	depth := 0
+	var quote rune
+	quoteAt := 0
+	escaped := false
 	for width, i := 0, start; i < len(data); i += width {
 		var r rune
 		r, width = utf8.DecodeRune(data[i:])
-		if r == '{' && i == 0 && !s.inJSON {
-			// The first rune of the word is a {, so it's the start of a JSON
-			// object.
-			s.inJSON = true
-			continue
-		}
-		if !s.inJSON && isSpace(r) {
+		switch {
+		case i == start && (r == '{' || r == '['):
+			depth++
+		case depth > 0:
+			// Within JSON, where only double quotes and backslashes
+			// within strings are special.
+			switch {
+			case escaped:
+				escaped = false
+			case quote != 0 && r == '\\':
+				escaped = true
+			case quote != 0:
+				if r == quote {
+					quote = 0
+				}
+			case r == '"':
+				quote = r
+			case r == '{' || r == '[':
+				depth++
+			case r == '}' || r == ']':
+				depth--
+			}
+		case literal:
+			if isSpace(r) {
+				s.offset += i + width
+				return i + width, data[start:i], nil
+			}
+		case escaped:
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote, quoteAt = r, i
+		case isSpace(r):
+			s.offset += i + width
 			return i + width, data[start:i], nil
 		}
 	}
 	// If we're at EOF, we have a final, non-empty, non-terminated word. Return it.
+	// Incomplete JSON is returned as is, for the JSON parser to complain about.
 	if atEOF && len(data) > start {
+		s.open = quote != 0 || depth > 0
+		if quote != 0 && depth == 0 {
+			return 0, nil, &syntaxError{s.offset + quoteAt, fmt.Sprintf("unterminated %c quote", quote)}
+		}
+		s.offset += len(data)
 		return len(data), data[start:], nil
 	}
 	// Request more data.
+	s.offset += start
 	return start, nil, nil
 }
 
-func parseCommand(line string) (command, error) {
+// continues returns the line without a trailing backslash and true if the
+// command continues on the next line, because the line ends with a
+// backslash, "&&" or "||", or within quotes or an unbalanced JSON object or
+// array.
+func continues(line string) (string, bool) {
+	n := len(line) - len(strings.TrimRight(line, "\\"))
+	if n%2 == 1 {
+		return line[:len(line)-1], true
+	}
+
+	var splitter wordOrJSONScanner
+	s := bufio.NewScanner(strings.NewReader(line))
+	s.Split(splitter.split)
+	for s.Scan() {
+	}
+	if splitter.open {
+		return line, true
+	}
+	if t := strings.TrimRight(line, " \t"); strings.HasSuffix(t, "&&") || strings.HasSuffix(t, "||") {
+		// A chain continues with the next command
+		return line + " ", true
+	}
+	return line, false
+}
+
+// A syntaxError is an error at a given byte offset in the command line.
+type syntaxError struct {
+	offset int
+	msg    string
+}
+
+func (e *syntaxError) Error() string {
+	return e.msg
+}
+
+// describe returns the error message with the column of the error, and the
+// line with a marker under the offending character.
+func (e *syntaxError) describe(line string) error {
+	col := utf8.RuneCountInString(line[:e.offset])
+	return fmt.Errorf("%s at column %d\n%s\n%s^", e.msg, col+1, line, strings.Repeat(" ", col))
+}
+
+// splitWords splits the line into words, keeping quoted strings, JSON
+// values and LDAP filters together.
+func splitWords(line string) ([]string, error) {
 	var fields []string
 	var splitter wordOrJSONScanner
 
@@ -59,6 +155,17 @@ func parseCommand(line string) (command, error) {
 		fields = append(fields, s.Text())
 	}
 	if err := s.Err(); err != nil {
+		if err, ok := err.(*syntaxError); ok {
+			return nil, err.describe(line)
+		}
+		return nil, err
+	}
+	return fields, nil
+}
+
+func parseCommand(line string) (command, error) {
+	fields, err := splitWords(line)
+	if err != nil {
 		return command{}, err
 	}
 
@@ -69,39 +176,328 @@ func parseCommand(line string) (command, error) {
 	// The command is the first two parts joined with a dot.
 
 	cmd := command{
-		Method: fields[0] + "." + fields[1],
+		Method: unquote(fields[0]) + "." + unquote(fields[1]),
 	}
 
-	// Look for key=val,key=val sequences among params and make them objects.
-	// Not stuff that starts with "(" though, because that might be an LDAP
-	// query expression.
-
 	for _, param := range fields[2:] {
-		if strings.HasPrefix(param, "{") {
-			var obj map[string]interface{}
-			err := json.Unmarshal([]byte(param), &obj)
-			if err != nil {
-				return command{}, err
-			}
-			cmd.Params = append(cmd.Params, obj)
-		} else if strings.Contains(param, "=") && !strings.HasPrefix(param, "(") {
-			parts := strings.Split(param, ",")
-			obj := map[string]string{}
-			for _, part := range parts {
-				if !strings.Contains(part, "=") {
-					obj[part] = ""
-					continue
+		v, err := parseParam(param)
+		if err != nil {
+			return command{}, err
+		}
+		cmd.Params = append(cmd.Params, v)
+	}
+
+	return cmd, nil
+}
+
+// A typedString is a string given with an explicit str: prefix. Unlike
+// plain words it's never converted to another type before sending.
+type typedString string
+
+// An attributes object is given in key=val,key=val syntax. Unlike objects
+// given as JSON, the values of attributes in it are converted to the types
+// they have in objects on the node before sending; see coerceParams.
+type attributes map[string]interface{}
+
+// A namedParam is a parameter given as --name=value or --name:value, to be
+// put in its place among the positional parameters by resolveNamed.
+type namedParam struct {
+	Name  string
+	Value interface{}
+}
+
+var namedExp = regexp.MustCompile(`^--([A-Za-z_][A-Za-z0-9_]*)[=:](.*)$`)
+
+// parseParam returns the value of a parameter word.
+func parseParam(word string) (interface{}, error) {
+	switch {
+	case strings.HasPrefix(word, "{"), strings.HasPrefix(word, "["):
+		return parseJSON(word)
+
+	case strings.HasPrefix(word, "("):
+		// An LDAP query expression, to be sent as is.
+		return word, nil
+
+	case strings.HasPrefix(word, "@"):
+		return loadValue(unquote(word[1:]))
+	}
+
+	if m := namedExp.FindStringSubmatch(word); m != nil {
+		v, err := parseParam(m[2])
+		if err != nil {
+			return nil, err
+		}
+		return namedParam{Name: m[1], Value: v}, nil
+	}
+
+	if v, ok, err := parseTyped(word); ok {
+		return v, err
+	}
+
+	// Look for key=val,key=val sequences and make them objects.
+	if indexUnquoted(word, '=') >= 0 {
+		return parseAttributes(word)
+	}
+
+	switch word {
+	case "null":
+		return nil, nil
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	return unquote(word), nil
+}
+
+// readStdin returns the data given on standard input, for @- parameters.
+var readStdin = func() ([]byte, error) {
+	return ioutil.ReadAll(os.Stdin)
+}
+
+// loadValue returns the value in the named JSON or YAML file, or standard
+// input for "-". Files ending in .yaml or .yml are YAML, others JSON. Data
+// on standard input is tried as JSON first and then as YAML.
+func loadValue(name string) (interface{}, error) {
+	var data []byte
+	var err error
+	if name == "-" {
+		data, err = readStdin()
+	} else {
+		data, err = ioutil.ReadFile(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch ext := strings.ToLower(filepath.Ext(name)); {
+	case ext == ".yaml" || ext == ".yml":
+		v, err := parseYAML(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		return v, nil
+
+	case name == "-":
+		if v, err := parseJSON(string(data)); err == nil {
+			return v, nil
+		}
+		v, err := parseYAML(data)
+		if err != nil {
+			return nil, fmt.Errorf("stdin: neither JSON nor %v", err)
+		}
+		return v, nil
+	}
+
+	v, err := parseJSON(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return v, nil
+}
+
+// parseJSON parses a JSON value, keeping numbers as json.Number so that
+// large integers survive unchanged.
+func parseJSON(s string) (interface{}, error) {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("unexpected data after JSON value in %s", s)
+	}
+	return v, nil
+}
+
+// parseTyped parses explicitly typed literals such as int:42, float:1.5,
+// bool:true, str:42 and json:[1,2]. The bool is false if the word has no
+// type prefix.
+func parseTyped(word string) (interface{}, bool, error) {
+	colon := strings.IndexByte(word, ':')
+	if colon < 0 {
+		return nil, false, nil
+	}
+
+	typ, val := word[:colon], word[colon+1:]
+	switch typ {
+	case "int":
+		v, err := coerceString(unquote(val), "integer")
+		return v, true, err
+	case "float":
+		v, err := coerceString(unquote(val), "number")
+		return v, true, err
+	case "bool":
+		v, err := coerceString(unquote(val), "boolean")
+		return v, true, err
+	case "str":
+		return typedString(unquote(val)), true, nil
+	case "json":
+		v, err := parseJSON(val)
+		return v, true, err
+	}
+	return nil, false, nil
+}
+
+// parseAttributes parses key=val,key=val syntax into an object. Dotted keys
+// create nested objects, so a.b=1 is {"a": {"b": "1"}}, and values in
+// brackets are lists, so tags=[x,y] is {"tags": ["x", "y"]}.
+func parseAttributes(word string) (attributes, error) {
+	obj := attributes{}
+	for _, part := range splitUnquoted(word, ',') {
+		key, val := part, ""
+		if eq := indexUnquoted(part, '='); eq >= 0 {
+			key, val = part[:eq], part[eq+1:]
+		}
+
+		v, err := parseValue(val)
+		if err != nil {
+			return nil, err
+		}
+
+		var path []string
+		for _, k := range splitUnquoted(key, '.') {
+			path = append(path, unquote(k))
+		}
+		if err := setPath(obj, path, v); err != nil {
+			return nil, err
+		}
+	}
+	return obj, nil
+}
+
+// parseValue parses the value part of a key=val pair.
+func parseValue(val string) (interface{}, error) {
+	if v, ok, err := parseTyped(val); ok {
+		return v, err
+	}
+
+	if strings.HasPrefix(val, "[") && strings.HasSuffix(val, "]") {
+		// A JSON array, or otherwise a list of words
+		if v, err := parseJSON(val); err == nil {
+			return v, nil
+		}
+		var list []interface{}
+		if inner := val[1 : len(val)-1]; inner != "" {
+			for _, e := range splitUnquoted(inner, ',') {
+				v, err := parseValue(e)
+				if err != nil {
+					return nil, err
 				}
-				kv := strings.SplitN(part, "=", 2)
-				obj[kv[0]] = kv[1]
+				list = append(list, v)
 			}
-			cmd.Params = append(cmd.Params, obj)
-		} else {
-			cmd.Params = append(cmd.Params, param)
 		}
+		return list, nil
 	}
 
-	return cmd, nil
+	if strings.HasPrefix(val, "{") {
+		return parseJSON(val)
+	}
+
+	if strings.HasPrefix(val, "@") {
+		return loadValue(unquote(val[1:]))
+	}
+
+	return unquote(val), nil
+}
+
+// setPath sets the value at the path of keys in the object, creating
+// intermediate objects as necessary.
+func setPath(obj map[string]interface{}, path []string, v interface{}) error {
+	for i, k := range path[:len(path)-1] {
+		next, ok := obj[k]
+		if !ok {
+			m := make(map[string]interface{})
+			obj[k] = m
+			obj = m
+			continue
+		}
+		m, ok := next.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s is both a value and an object", strings.Join(path[:i+1], "."))
+		}
+		obj = m
+	}
+
+	k := path[len(path)-1]
+	if _, ok := obj[k].(map[string]interface{}); ok {
+		return fmt.Errorf("%s is both a value and an object", strings.Join(path, "."))
+	}
+	obj[k] = v
+	return nil
+}
+
+// indexUnquoted returns the index of the first c in s that is not quoted,
+// escaped or within brackets, or -1.
+func indexUnquoted(s string, c rune) int {
+	var quote rune
+	escaped := false
+	depth := 0
+	for i, r := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '[' || r == '{':
+			depth++
+		case r == ']' || r == '}':
+			depth--
+		case r == c && depth == 0:
+			return i
+		}
+	}
+	return -1
+}
+
+// splitUnquoted splits s at each c that is not quoted, escaped or within
+// brackets.
+func splitUnquoted(s string, c rune) []string {
+	var parts []string
+	for {
+		i := indexUnquoted(s, c)
+		if i < 0 {
+			return append(parts, s)
+		}
+		parts = append(parts, s[:i])
+		s = s[i+utf8.RuneLen(c):]
+	}
+}
+
+// unquote removes quotes and backslash escapes, the way a shell does. Text
+// within single quotes is taken literally, while a backslash escapes the
+// next character anywhere else.
+func unquote(s string) string {
+	if !strings.ContainsAny(s, `"'\`) {
+		return s
+	}
+
+	var res []rune
+	var quote rune
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			res = append(res, r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote == 0 && (r == '"' || r == '\''):
+			quote = r
+		default:
+			res = append(res, r)
+		}
+	}
+	return string(res)
 }
 
 // isSpace reports whether the character is a Unicode white space character.
@@ -1,6 +1,10 @@
 package main
 
 import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 )
@@ -20,21 +24,21 @@ func TestParseCommand(t *testing.T) {
 			"object update subscriber 1234 foo=bar",
 			command{
 				Method: "object.update",
-				Params: []interface{}{"subscriber", "1234", map[string]string{"foo": "bar"}},
+				Params: []interface{}{"subscriber", "1234", attributes{"foo": "bar"}},
 			},
 		},
 		{
 			"object update subscriber 1234 foo=bar,baz=quux",
 			command{
 				Method: "object.update",
-				Params: []interface{}{"subscriber", "1234", map[string]string{"foo": "bar", "baz": "quux"}},
+				Params: []interface{}{"subscriber", "1234", attributes{"foo": "bar", "baz": "quux"}},
 			},
 		},
 		{
 			"object update subscriber 1234 foo=bar baz=quux",
 			command{
 				Method: "object.update",
-				Params: []interface{}{"subscriber", "1234", map[string]string{"foo": "bar"}, map[string]string{"baz": "quux"}},
+				Params: []interface{}{"subscriber", "1234", attributes{"foo": "bar"}, attributes{"baz": "quux"}},
 			},
 		},
 		{
@@ -55,7 +59,73 @@ func TestParseCommand(t *testing.T) {
 			`object update subscriber 1234 foo=,bar`,
 			command{
 				Method: "object.update",
-				Params: []interface{}{"subscriber", "1234", map[string]string{"foo": "", "bar": ""}},
+				Params: []interface{}{"subscriber", "1234", attributes{"foo": "", "bar": ""}},
+			},
+		},
+		{
+			`object update subscriber [1, 2, 288230376151715606] ["a b", {"c": null}] x`,
+			command{
+				Method: "object.update",
+				Params: []interface{}{
+					"subscriber",
+					[]interface{}{json.Number("1"), json.Number("2"), json.Number("288230376151715606")},
+					[]interface{}{"a b", map[string]interface{}{"c": nil}},
+					"x",
+				},
+			},
+		},
+		{
+			`object update subscriber null true false "null" int:42 float:1.5 bool:false str:42 json:"x"`,
+			command{
+				Method: "object.update",
+				Params: []interface{}{"subscriber", nil, true, false, "null", json.Number("42"), json.Number("1.5"), false, typedString("42"), "x"},
+			},
+		},
+		{
+			`object update subscriber 1234 a.b=1,a.c=str:2,tags=[x,"y z"],list=[1,2],empty=[]`,
+			command{
+				Method: "object.update",
+				Params: []interface{}{"subscriber", "1234", attributes{
+					"a":     map[string]interface{}{"b": "1", "c": typedString("2")},
+					"tags":  []interface{}{"x", "y z"},
+					"list":  []interface{}{json.Number("1"), json.Number("2")},
+					"empty": []interface{}{},
+				}},
+			},
+		},
+		{
+			`object update subscriber 1234 "a.b"=1`,
+			command{
+				Method: "object.update",
+				Params: []interface{}{"subscriber", "1234", attributes{"a.b": "1"}},
+			},
+		},
+		{
+			`object update subscriber "my sub" hostName="my host",note='a,b'`,
+			command{
+				Method: "object.update",
+				Params: []interface{}{"subscriber", "my sub", attributes{"hostName": "my host", "note": "a,b"}},
+			},
+		},
+		{
+			`object update subscriber my\ sub "a=b" 'x"y' "x\"y" 'x\y'`,
+			command{
+				Method: "object.update",
+				Params: []interface{}{"subscriber", "my sub", "a=b", `x"y`, `x"y`, `x\y`},
+			},
+		},
+		{
+			`object update subscriber 1234 "key=1"=val,k2="v=2"`,
+			command{
+				Method: "object.update",
+				Params: []interface{}{"subscriber", "1234", attributes{"key=1": "val", "k2": "v=2"}},
+			},
+		},
+		{
+			`object list subscriber (cn=O'Brien\2a)`,
+			command{
+				Method: "object.list",
+				Params: []interface{}{"subscriber", `(cn=O'Brien\2a)`},
 			},
 		},
 	}
@@ -71,3 +141,113 @@ func TestParseCommand(t *testing.T) {
 		}
 	}
 }
+
+func TestParseCommandErrors(t *testing.T) {
+	testcases := []struct {
+		line string
+		err  string
+	}{
+		{
+			"system",
+			"incomplete command",
+		},
+		{
+			"object update subscriber a=1,a.b=2",
+			"a is both a value and an object",
+		},
+		{
+			"object update subscriber int:x",
+			`"x" is not an integer`,
+		},
+		{
+			`object update subscriber 1234 hostName="my host`,
+			"unterminated \" quote at column 40\n" +
+				`object update subscriber 1234 hostName="my host` + "\n" +
+				"                                       ^",
+		},
+		{
+			`object update 'subscriber`,
+			"unterminated ' quote at column 15\n" +
+				`object update 'subscriber` + "\n" +
+				"              ^",
+		},
+	}
+
+	for _, tc := range testcases {
+		_, err := parseCommand(tc.line)
+		if err == nil {
+			t.Errorf("Unexpected nil error for %q", tc.line)
+			continue
+		}
+		if err.Error() != tc.err {
+			t.Errorf("Incorrect error for %q:\n%s\n%s", tc.line, err, tc.err)
+		}
+	}
+}
+
+func TestParseCommandFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "psmcli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	jsonFile := filepath.Join(dir, "obj.json")
+	ioutil.WriteFile(jsonFile, []byte(`{"a": [1, 288230376151715606]}`+"\n"), 0644)
+	yamlFile := filepath.Join(dir, "obj.yaml")
+	ioutil.WriteFile(yamlFile, []byte("a:\n  - 1\n  - 288230376151715606\n"), 0644)
+
+	readStdin = func() ([]byte, error) {
+		return []byte("a: [1, 288230376151715606]"), nil
+	}
+	defer func() {
+		readStdin = func() ([]byte, error) {
+			return ioutil.ReadAll(os.Stdin)
+		}
+	}()
+
+	exp := map[string]interface{}{"a": []interface{}{json.Number("1"), json.Number("288230376151715606")}}
+	for _, param := range []string{"@" + jsonFile, "@" + yamlFile, "@-"} {
+		cmd, err := parseCommand("object update " + param + " x=" + param)
+		if err != nil {
+			t.Errorf("Unexpected error for %s: %v", param, err)
+			continue
+		}
+		params := []interface{}{exp, attributes{"x": exp}}
+		if !reflect.DeepEqual(cmd.Params, params) {
+			t.Errorf("Incorrect parameters for %s:\n\t%#v\n\t%#v", param, cmd.Params, params)
+		}
+	}
+
+	if _, err := parseCommand("object update @" + filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("Unexpected nil error for missing file")
+	}
+}
+
+func TestContinues(t *testing.T) {
+	testcases := []struct {
+		line  string
+		cont  string
+		multi bool
+	}{
+		{"system hostname", "system hostname", false},
+		{`object update subscriber 1234 {"a": 1}`, `object update subscriber 1234 {"a": 1}`, false},
+		{`object update subscriber 1234 {"a": `, `object update subscriber 1234 {"a": `, true},
+		{`object update subscriber 1234 [{"a": "}"}`, `object update subscriber 1234 [{"a": "}"}`, true},
+		{`object update subscriber 1234 note="a`, `object update subscriber 1234 note="a`, true},
+		{`object update subscriber 1234 'a \`, `object update subscriber 1234 'a `, true},
+		{`object update subscriber 1234 \`, `object update subscriber 1234 `, true},
+		{`object update subscriber 1234 a\\`, `object update subscriber 1234 a\\`, false},
+		{`object list subscriber (cn=O'Brien)`, `object list subscriber (cn=O'Brien)`, false},
+		{`system hostname &&`, `system hostname && `, true},
+		{`system hostname || `, `system hostname ||  `, true},
+		{`system hostname "&&"`, `system hostname "&&"`, false},
+	}
+
+	for _, tc := range testcases {
+		cont, multi := continues(tc.line)
+		if cont != tc.cont || multi != tc.multi {
+			t.Errorf("Incorrect continuation of %q: %q %v != %q %v", tc.line, cont, multi, tc.cont, tc.multi)
+		}
+	}
+}
@@ -0,0 +1,497 @@
+// psmcli
+// Copyright (C) 2014 Procera Networks, Inc.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+// The kinds of plan actions.
+const (
+	actionCreate = "create"
+	actionUpdate = "update"
+	actionDelete = "delete"
+)
+
+// A desired state file maps object types to lists of objects, each with
+// its application ID and the attributes to manage:
+//
+//	subscriber:
+//	  - subscriberId: "1234"
+//	    hostName: web1
+//	    persistent: true
+//	    parentOid: group g1
+//
+// Attributes not given are left as they are on the node. The parentOid is
+// the type and application ID of the parent, resolved to its OID on the
+// node when the changes are made.
+type desiredState map[string][]map[string]interface{}
+
+// planAction is a change needed for the node to match the desired state.
+// The depth is the number of parents above the object.
+type planAction struct {
+	kind    string
+	typ     string
+	aid     string
+	attrs   map[string]interface{}
+	changes []attributeDiff
+	depth   int
+}
+
+// parentRef returns the type and application ID in a parentOid reference,
+// e.g. "group g1".
+func parentRef(v interface{}) (typ, aid string, ok bool) {
+	s, ok := v.(string)
+	if !ok {
+		return "", "", false
+	}
+	fields := strings.SplitN(s, " ", 2)
+	if len(fields) != 2 || fields[0] == "" || strings.TrimSpace(fields[1]) == "" {
+		return "", "", false
+	}
+	return fields[0], strings.TrimSpace(fields[1]), true
+}
+
+// parseDesiredState checks and converts the value read from a desired
+// state file.
+func parseDesiredState(v interface{}) (desiredState, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected object types mapped to lists of objects")
+	}
+
+	state := make(desiredState)
+	for typ, list := range m {
+		objs, ok := list.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s: expected a list of objects", typ)
+		}
+		seen := make(map[string]bool)
+		for i, o := range objs {
+			obj, ok := o.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s[%d]: expected an object", typ, i)
+			}
+			aid := objectAid(typ, obj)
+			if aid == "" {
+				return nil, fmt.Errorf("%s[%d]: missing %s", typ, i, aidAttribute(typ))
+			}
+			if seen[aid] {
+				return nil, fmt.Errorf("%s[%d]: %s %s is given more than once", typ, i, typ, aid)
+			}
+			seen[aid] = true
+			for _, f := range serverFields {
+				if _, ok := obj[f]; ok {
+					return nil, fmt.Errorf("%s %s: %s is managed by PSM", typ, aid, f)
+				}
+			}
+			if p, ok := obj["parentOid"]; ok && p != nil {
+				if _, _, ok := parentRef(p); !ok {
+					return nil, fmt.Errorf("%s %s: parentOid must be the type and application ID of the parent, e.g. \"group g1\"", typ, aid)
+				}
+			}
+			state[typ] = append(state[typ], obj)
+		}
+	}
+	return state, nil
+}
+
+// sameValue returns true if the desired value matches the live one. A
+// string matches a value of another type written the same way, as values
+// in YAML files are often quoted.
+func sameValue(desired, live interface{}) bool {
+	if s, ok := desired.(string); ok {
+		if _, ok := live.(string); !ok && live != nil {
+			return s == plainString(live)
+		}
+	}
+	a, _ := json.Marshal(desired)
+	b, _ := json.Marshal(live)
+	return bytes.Equal(a, b)
+}
+
+// planActions returns the actions making the live objects match the
+// desired state, ordered so that parents are created before their
+// children and deleted after them. The parentOid of the live objects is
+// expected as a reference like in the desired state. Objects of the types
+// in the desired state that aren't in it are deleted only if prune is set.
+func planActions(state desiredState, live map[string]map[string]map[string]interface{}, prune bool) []planAction {
+	var types []string
+	for typ := range state {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+
+	wanted := make(map[string]map[string]interface{})
+	for _, typ := range types {
+		for _, obj := range state[typ] {
+			wanted[typ+" "+objectAid(typ, obj)] = obj
+		}
+	}
+	existing := make(map[string]map[string]interface{})
+	for typ, objs := range live {
+		for aid, obj := range objs {
+			existing[typ+" "+aid] = obj
+		}
+	}
+
+	var actions []planAction
+	for _, typ := range types {
+		for _, obj := range state[typ] {
+			aid := objectAid(typ, obj)
+			depth := parentDepth(obj, wanted)
+
+			cur, ok := live[typ][aid]
+			if !ok {
+				actions = append(actions, planAction{kind: actionCreate, typ: typ, aid: aid, attrs: obj, depth: depth})
+				continue
+			}
+
+			var names []string
+			for k := range obj {
+				names = append(names, k)
+			}
+			sort.Strings(names)
+			a := planAction{kind: actionUpdate, typ: typ, aid: aid, attrs: make(map[string]interface{}), depth: depth}
+			for _, k := range names {
+				if k == aidAttribute(typ) || sameValue(obj[k], cur[k]) {
+					continue
+				}
+				a.attrs[k] = obj[k]
+				a.changes = append(a.changes, attributeDiff{Name: k, Old: cur[k], New: obj[k]})
+			}
+			if len(a.changes) > 0 {
+				actions = append(actions, a)
+			}
+		}
+
+		if !prune {
+			continue
+		}
+		var aids []string
+		for aid := range live[typ] {
+			if _, ok := wanted[typ+" "+aid]; !ok {
+				aids = append(aids, aid)
+			}
+		}
+		sort.Strings(aids)
+		for _, aid := range aids {
+			actions = append(actions, planAction{kind: actionDelete, typ: typ, aid: aid, depth: parentDepth(live[typ][aid], existing)})
+		}
+	}
+
+	sort.SliceStable(actions, func(i, j int) bool {
+		return actionOrder(actions[i]) < actionOrder(actions[j])
+	})
+	return actions
+}
+
+// parentDepth returns the number of parents above the object among the
+// objects, by reference.
+func parentDepth(obj map[string]interface{}, objs map[string]map[string]interface{}) int {
+	depth := 0
+	for depth <= len(objs) {
+		p, ok := obj["parentOid"].(string)
+		if !ok {
+			break
+		}
+		if obj, ok = objs[p]; !ok {
+			break
+		}
+		depth++
+	}
+	return depth
+}
+
+// actionOrder returns the position of the action in a plan: changes of
+// objects by increasing depth, then deletions by decreasing depth.
+func actionOrder(a planAction) int {
+	if a.kind == actionDelete {
+		return math.MaxInt32 - a.depth
+	}
+	return a.depth
+}
+
+// writePlan writes the actions as text, in the style of diff.
+func writePlan(w io.Writer, actions []planAction) {
+	counts := make(map[string]int)
+	for _, a := range actions {
+		counts[a.kind]++
+		switch a.kind {
+		case actionCreate:
+			fmt.Fprintf(w, "+ create %s %s\n", a.typ, a.aid)
+			var names []string
+			for k := range a.attrs {
+				if k != aidAttribute(a.typ) {
+					names = append(names, k)
+				}
+			}
+			sort.Strings(names)
+			for _, k := range names {
+				fmt.Fprintf(w, "    %s: %s\n", k, diffValue(a.attrs[k]))
+			}
+		case actionUpdate:
+			fmt.Fprintf(w, "~ update %s %s\n", a.typ, a.aid)
+			for _, c := range a.changes {
+				fmt.Fprintf(w, "    %s: %s -> %s\n", c.Name, diffValue(c.Old), diffValue(c.New))
+			}
+		case actionDelete:
+			fmt.Fprintf(w, "- delete %s %s\n", a.typ, a.aid)
+		}
+	}
+
+	if len(actions) == 0 {
+		fmt.Fprintln(w, "No changes; the node matches the desired state")
+		return
+	}
+	fmt.Fprintf(w, "%d changes: %d to create, %d to update, %d to delete\n", len(actions), counts[actionCreate], counts[actionUpdate], counts[actionDelete])
+}
+
+// liveObjects returns the objects of the types on the node, by type and
+// application ID, with parentOid replaced by a reference to the parent
+// when it's among them. The OIDs of the objects are returned by
+// reference.
+func (s *session) liveObjects(types []string) (map[string]map[string]map[string]interface{}, map[string]interface{}, bool, error) {
+	live := make(map[string]map[string]map[string]interface{})
+	oids := make(map[string]interface{})
+	refs := make(map[string]string)
+	for _, typ := range types {
+		objs := make(map[string]map[string]interface{})
+		ok, err := s.listObjects(typ, defaultPageSize, func(obj map[string]interface{}) error {
+			if aid := objectAid(typ, obj); aid != "" {
+				objs[aid] = obj
+				if oid, ok := obj["oid"]; ok && oid != nil {
+					oids[typ+" "+aid] = oid
+					refs[plainString(oid)] = typ + " " + aid
+				}
+			}
+			return nil
+		})
+		if err != nil || !ok {
+			return nil, nil, false, err
+		}
+		live[typ] = objs
+	}
+
+	for _, objs := range live {
+		for _, obj := range objs {
+			if p, ok := obj["parentOid"]; ok && p != nil {
+				if ref, ok := refs[plainString(p)]; ok {
+					obj["parentOid"] = ref
+				}
+			}
+		}
+	}
+	return live, oids, true, nil
+}
+
+// plan returns the actions making the node match the desired state, and
+// the OIDs on the node by reference. The parents' types are listed along
+// with those in the desired state.
+func (s *session) plan(state desiredState, prune bool) ([]planAction, map[string]interface{}, bool, error) {
+	seen := make(map[string]bool)
+	var types []string
+	add := func(typ string) {
+		if !seen[typ] {
+			seen[typ] = true
+			types = append(types, typ)
+		}
+	}
+	for typ, objs := range state {
+		add(typ)
+		for _, obj := range objs {
+			if ptyp, _, ok := parentRef(obj["parentOid"]); ok {
+				add(ptyp)
+			}
+		}
+	}
+	sort.Strings(types)
+
+	live, oids, ok, err := s.liveObjects(types)
+	if err != nil || !ok {
+		return nil, nil, false, err
+	}
+	for typ := range live {
+		if _, ok := state[typ]; !ok {
+			// Listed only for the parents
+			delete(live, typ)
+		}
+	}
+	return planActions(state, live, prune), oids, true, nil
+}
+
+// apply carries out the actions, stopping at the first failure, and then
+// plans again to verify that the node matches the desired state. Parent
+// references are resolved with the OIDs from plan, and those of the
+// objects created.
+func (s *session) apply(state desiredState, actions []planAction, oids map[string]interface{}, prune bool) (bool, error) {
+	stop := func(i int, a planAction, format string, args ...interface{}) {
+		fmt.Fprintf(s.out, "%s %s %s: %s\n", a.kind, a.typ, a.aid, fmt.Sprintf(format, args...))
+		fmt.Fprintf(s.out, "Stopped after %d of %d changes\n", i, len(actions))
+	}
+
+	// Objects created without getting their OID, which are looked up by
+	// application ID when needed as parents
+	created := make(map[string]bool)
+
+	for i, a := range actions {
+		attrs := make(attributes, len(a.attrs))
+		for k, v := range a.attrs {
+			attrs[k] = v
+		}
+		if p, ok := attrs["parentOid"]; ok && p != nil {
+			oid, ok := oids[plainString(p)]
+			if typ, _, isRef := parentRef(p); !ok && isRef && created[plainString(p)] {
+				existing, listed, err := s.existingObjects([]string{typ})
+				if err != nil {
+					return false, err
+				}
+				if listed {
+					for aid, oid := range existing[typ] {
+						if ref := typ + " " + aid; created[ref] {
+							oids[ref] = oid
+							delete(created, ref)
+						}
+					}
+				}
+				oid, ok = oids[plainString(p)]
+			}
+			if !ok {
+				stop(i, a, "parent %s isn't on the node", plainString(p))
+				return false, nil
+			}
+			attrs["parentOid"] = oid
+		}
+
+		var cmd command
+		var err error
+		switch a.kind {
+		case actionCreate:
+			cmd, err = objectCommand(s.services, methodCreate, a.typ, attrs)
+		case actionUpdate:
+			cmd, err = objectCommand(s.services, methodUpdateByAid, a.typ, a.aid, attrs)
+		case actionDelete:
+			cmd, err = objectCommand(s.services, methodDeleteByAid, a.typ, a.aid)
+		}
+		if err != nil {
+			stop(i, a, "%v", err)
+			return false, nil
+		}
+
+		cmd.ID = s.id
+		s.id++
+		res, err := s.conn.run(cmd)
+		if _, ok := err.(encodeError); ok {
+			stop(i, a, "%v", err)
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if res.Error.Code != 0 {
+			stop(i, a, "%s", res.Error.Message)
+			return false, nil
+		}
+		if a.kind == actionCreate {
+			if oid, ok := createdOid(res.Result); ok {
+				oids[a.typ+" "+a.aid] = oid
+			} else {
+				created[a.typ+" "+a.aid] = true
+			}
+		}
+		fmt.Fprintf(s.out, "%sd %s %s\n", a.kind, a.typ, a.aid)
+	}
+
+	// Read back the objects to verify the changes
+
+	remaining, _, ok, err := s.plan(state, prune)
+	if err != nil || !ok {
+		return false, err
+	}
+	if len(remaining) > 0 {
+		fmt.Fprintln(s.out, "Verification failed; the node still differs from the desired state:")
+		writePlan(s.out, remaining)
+		return false, nil
+	}
+	fmt.Fprintln(s.out, "Verified; the node matches the desired state")
+	return true, nil
+}
+
+// planMain runs "psmcli plan" and "psmcli apply". Apply asks for
+// confirmation, unless -y is given, before changing anything.
+func planMain(name string, args []string, user string, verbose bool) int {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	prune := fs.Bool("prune", false, "Delete objects of the types in the file that aren't in it")
+	yes := new(bool)
+	if name == "apply" {
+		yes = fs.Bool("y", false, "Apply the changes without asking for confirmation")
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		opts := "[-prune]"
+		if name == "apply" {
+			opts = "[-prune] [-y]"
+		}
+		fmt.Fprintf(os.Stderr, "Usage: psmcli [options] %s %s <host:port | profile> <file.yaml | file.json>\n", name, opts)
+		return 2
+	}
+
+	v, err := loadValue(fs.Arg(1))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	state, err := parseDesiredState(v)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", fs.Arg(1), err)
+		return 2
+	}
+
+	s, err := connectBatch(fs.Arg(0), user, os.Stdout, verbose)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	actions, oids, ok, err := s.plan(state, *prune)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if !ok {
+		return 1
+	}
+	writePlan(os.Stdout, actions)
+	if name == "plan" || len(actions) == 0 {
+		return 0
+	}
+
+	if !*yes {
+		fmt.Printf("Apply %d changes to %s? [y/N] ", len(actions), fs.Arg(0))
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+			fmt.Println("Nothing changed")
+			return 1
+		}
+	}
+
+	ok, err = s.apply(state, actions, oids, *prune)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if !ok {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDesiredState(t *testing.T) {
+	testcases := []struct {
+		yaml string
+		err  bool
+	}{
+		{"subscriber:\n  - subscriberId: a\n    hostName: web1\ngroup:\n  - aid: g1\n", false},
+		{"subscriber: []\n", false},
+		{"- a\n", true},
+		{"subscriber: a\n", true},
+		{"subscriber:\n  - a\n", true},
+		{"subscriber:\n  - hostName: web1\n", true},
+		{"subscriber:\n  - subscriberId: a\n  - subscriberId: a\n", true},
+		{"subscriber:\n  - subscriberId: a\n    oid: 1\n", true},
+		{"subscriber:\n  - subscriberId: a\n    parentOid: group g1\n", false},
+		{"subscriber:\n  - subscriberId: a\n    parentOid: null\n", false},
+		{"subscriber:\n  - subscriberId: a\n    parentOid: 10\n", true},
+		{"subscriber:\n  - subscriberId: a\n    parentOid: group\n", true},
+	}
+
+	for _, tc := range testcases {
+		v, err := parseYAML([]byte(tc.yaml))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = parseDesiredState(v)
+		if tc.err && err == nil {
+			t.Errorf("Unexpected nil error for %q", tc.yaml)
+		} else if !tc.err && err != nil {
+			t.Errorf("Unexpected error for %q: %v", tc.yaml, err)
+		}
+	}
+}
+
+func TestPlanActions(t *testing.T) {
+	state := desiredState{
+		"subscriber": {
+			{"subscriberId": "a", "hostName": "web1", "slot": "80"},
+			{"subscriberId": "b", "parentOid": "group g1"},
+			{"subscriberId": "c", "persistent": true},
+			{"subscriberId": "e", "parentOid": "group g3"},
+			{"subscriberId": "f", "parentOid": nil},
+		},
+		"group": {{"aid": "g1"}, {"aid": "g3"}},
+	}
+	live := map[string]map[string]map[string]interface{}{
+		"subscriber": {
+			"a": {"subscriberId": "a", "hostName": "old", "slot": json.Number("80")},
+			"c": {"subscriberId": "c", "persistent": true},
+			"d": {"subscriberId": "d", "parentOid": "group g2"},
+		},
+		"group": {
+			"g1": {"aid": "g1"},
+			"g2": {"aid": "g2"},
+		},
+	}
+
+	// Parents are created before their children and deleted after them
+	changes := `+ create group g3
+~ update subscriber a
+    hostName: "old" -> "web1"
++ create subscriber f
+    parentOid: (none)
++ create subscriber b
+    parentOid: "group g1"
++ create subscriber e
+    parentOid: "group g3"
+`
+	testcases := []struct {
+		prune bool
+		plan  string
+	}{
+		{false, changes + "5 changes: 4 to create, 1 to update, 0 to delete\n"},
+		{true, changes + `- delete subscriber d
+- delete group g2
+7 changes: 4 to create, 1 to update, 2 to delete
+`},
+	}
+
+	for _, tc := range testcases {
+		var buf bytes.Buffer
+		writePlan(&buf, planActions(state, live, tc.prune))
+		if buf.String() != tc.plan {
+			t.Errorf("Incorrect plan with prune %v:\n%s\n!= expected\n%s", tc.prune, buf.String(), tc.plan)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	f := &fakePSM{nextOid: 100, objects: map[string][]map[string]interface{}{
+		"subscriber": {
+			{"oid": json.Number("1"), "subscriberId": "a", "hostName": "old"},
+			{"oid": json.Number("2"), "subscriberId": "d"},
+		},
+		"group": {},
+	}}
+	s, out := testSession(f.handle)
+
+	// The group and its subscriber are created in the same apply
+	state := desiredState{
+		"subscriber": {
+			{"subscriberId": "a", "hostName": "web1"},
+			{"subscriberId": "b", "hostName": "web2", "parentOid": "group g1"},
+		},
+		"group": {{"aid": "g1"}},
+	}
+	actions, oids, ok, err := s.plan(state, true)
+	if err != nil || !ok {
+		t.Fatalf("Unexpected plan failure: %v\n%s", err, out)
+	}
+	ok, err = s.apply(state, actions, oids, true)
+	if err != nil || !ok {
+		t.Fatalf("Unexpected apply failure: %v\n%s", err, out)
+	}
+
+	exp := []string{
+		"object.create [group map[aid:g1]]",
+		"object.updateByAid [subscriber a map[hostName:web1]]",
+		"object.create [subscriber map[hostName:web2 parentOid:101 subscriberId:b]]",
+		"object.deleteByAid [subscriber d]",
+	}
+	if !reflect.DeepEqual(f.calls, exp) {
+		t.Errorf("Incorrect calls:\n%q\n!= expected\n%q", f.calls, exp)
+	}
+	if !strings.Contains(out.String(), "Verified") {
+		t.Errorf("Missing verification in output:\n%s", out)
+	}
+
+	// The OID of a created parent is looked up by application ID when
+	// object.create doesn't return it
+
+	g := &fakePSM{nextOid: 100, createTrue: true, objects: map[string][]map[string]interface{}{
+		"subscriber": {},
+		"group":      {},
+	}}
+	s, out = testSession(g.handle)
+	actions, oids, _, _ = s.plan(state, false)
+	if ok, err := s.apply(state, actions, oids, false); !ok || err != nil {
+		t.Fatalf("Unexpected apply failure: %v\n%s", err, out)
+	}
+	if exp := "object.create [subscriber map[hostName:web2 parentOid:101 subscriberId:b]]"; len(g.calls) != 3 || g.calls[2] != exp {
+		t.Errorf("Incorrect calls:\n%q\n!= expected last\n%q", g.calls, exp)
+	}
+
+	// An update that doesn't take fails the verification
+
+	s, out = testSession(func(cmd command) (interface{}, int) {
+		if cmd.Method == methodUpdateByAid {
+			return nil, 0
+		}
+		return f.handle(cmd)
+	})
+	state["subscriber"][0]["hostName"] = "web3"
+	actions, oids, _, _ = s.plan(state, false)
+	if ok, _ := s.apply(state, actions, oids, false); ok || !strings.Contains(out.String(), "Verification failed") {
+		t.Errorf("Unexpected success of apply with an update that doesn't take\n%s", out)
+	}
+}
@@ -0,0 +1,105 @@
+// psmcli
+// Copyright (C) 2014 Procera Networks, Inc.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A profile is a named PSM node, defined in the config file as
+//
+//	profile name host[:port] [user]
+//
+// and used in place of the host on the command line. A profile may have
+// its own startup script, ~/.psmcli/<name>/init.psm.
+type profile struct {
+	name string
+	host string
+	user string
+}
+
+// readProfiles returns the profiles defined in the config file, if it
+// exists.
+func readProfiles(name string) (map[string]profile, error) {
+	fd, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	profiles := make(map[string]profile)
+	sc := bufio.NewScanner(fd)
+	for num := 1; sc.Scan(); num++ {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || fields[0] != "profile" {
+			continue
+		}
+		if len(fields) < 3 || len(fields) > 4 || !identExp.MatchString(fields[1]) {
+			return nil, fmt.Errorf("%s:%d: expected profile name host[:port] [user]", name, num)
+		}
+		p := profile{name: fields[1], host: fields[2]}
+		if len(fields) == 4 {
+			p.user = fields[3]
+		}
+		profiles[p.name] = p
+	}
+	return profiles, sc.Err()
+}
+
+// resolveDestination returns the address for the destination given on the
+// command line, a host[:port] or the name of a profile, and the profile if
+// it's one. The port defaults to 3994.
+func resolveDestination(dst string, profiles map[string]profile) (string, profile, error) {
+	p, ok := profiles[dst]
+	if ok {
+		dst = p.host
+	}
+
+	host, port, err := net.SplitHostPort(dst)
+	if err != nil && strings.Contains(err.Error(), "missing port") {
+		dst = net.JoinHostPort(dst, "3994")
+	} else if err != nil {
+		return "", p, err
+	} else if port == "" {
+		dst = net.JoinHostPort(host, "3994")
+	}
+	return dst, p, nil
+}
+
+// runInit runs the global startup script ~/.psmcli/init.psm and then that
+// of the profile, if any, as if they were typed in the REPL. Their exit
+// codes are reported if not zero.
+func (s *session) runInit(profile string) error {
+	names := []string{filepath.Join(configDir(), "init.psm")}
+	if profile != "" {
+		names = append(names, filepath.Join(configDir(), profile, "init.psm"))
+	}
+
+	for _, name := range names {
+		fd, err := os.Open(name)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			fmt.Fprintln(s.out, err)
+			continue
+		}
+
+		code, err := s.runScript(fd)
+		fd.Close()
+		if err != nil {
+			return err
+		}
+		if code != 0 {
+			fmt.Fprintf(s.out, "%s: exit code %d\n", name, code)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadProfiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "psmcli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "config")
+	ioutil.WriteFile(name, []byte("alias sub = subscriber getByAid\nprofile lab psm1.example.com admin\nprofile prod 10.0.0.1:3994\n"), 0644)
+
+	profiles, err := readProfiles(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := map[string]profile{
+		"lab":  {"lab", "psm1.example.com", "admin"},
+		"prod": {"prod", "10.0.0.1:3994", ""},
+	}
+	if !reflect.DeepEqual(profiles, exp) {
+		t.Errorf("Incorrect profiles %v", profiles)
+	}
+
+	ioutil.WriteFile(name, []byte("profile lab\n"), 0644)
+	if _, err := readProfiles(name); err == nil {
+		t.Error("Missing error for profile without host")
+	}
+}
+
+func TestRunInit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "psmcli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	home := os.Getenv("HOME")
+	os.Setenv("HOME", dir)
+	defer os.Setenv("HOME", home)
+
+	os.MkdirAll(filepath.Join(dir, ".psmcli", "lab"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, ".psmcli", "init.psm"), []byte("alias sub = subscriber getByAid\nformat human\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, ".psmcli", "lab", "init.psm"), []byte("set v = system version\nexpect $v == 6\n"), 0644)
+
+	var methods []string
+	s, out := testSession(recordingHandler(&methods))
+	if err := s.runInit("lab"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.aliases["sub"]; !ok || s.renderer.format != formatHuman {
+		t.Error("Global init script not run")
+	}
+	if !reflect.DeepEqual(methods, []string{"system.version"}) {
+		t.Errorf("Profile init script not run: %q", methods)
+	}
+	if !strings.Contains(out.String(), "lab/init.psm: exit code 1") {
+		t.Errorf("Missing exit code report in output:\n%s", out)
+	}
+}
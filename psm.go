@@ -4,8 +4,10 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"net"
+	"strconv"
 )
 
 const (
@@ -25,6 +27,14 @@ type response struct {
 		Code    int
 		Message string
 	}
+
+	// RawResult is the result exactly as sent by the server, with the
+	// original member order, formatting and number representation.
+	RawResult json.RawMessage `json:"-"`
+
+	// RawID is the ID as sent, which for raw requests may be other
+	// than an integer; ID is then zero.
+	RawID json.RawMessage `json:"-"`
 }
 
 type connection struct {
@@ -51,16 +61,83 @@ func newConnection(addr string) (*connection, error) {
 }
 
 func (c *connection) run(cmd command) (response, error) {
-	err := c.enc.Encode(cmd)
-	if err != nil {
+	if err := c.send(cmd); err != nil {
 		return response{}, err
 	}
+	return c.receive()
+}
 
-	var res response
-	err = c.dec.Decode(&res)
+// encodeError is returned for commands that can't be encoded as JSON.
+// Nothing is sent, so the connection can still be used.
+type encodeError struct {
+	err error
+}
+
+func (e encodeError) Error() string {
+	return e.err.Error()
+}
+
+// send sends the command without waiting for the response, which is read
+// with receive. Several commands may be outstanding at once; responses are
+// matched to them by ID.
+func (c *connection) send(cmd command) error {
+	bs, err := json.Marshal(cmd)
 	if err != nil {
+		return encodeError{err}
+	}
+	_, err = c.conn.Write(append(bs, '\n'))
+	return err
+}
+
+// receive reads the next response.
+func (c *connection) receive() (response, error) {
+	var raw json.RawMessage
+	if err := c.dec.Decode(&raw); err != nil {
+		return response{}, err
+	}
+	return decodeResponse(raw)
+}
+
+// decodeResponse decodes the response, keeping the raw bytes of the result.
+func decodeResponse(raw []byte) (response, error) {
+	// The outer ID hides that of the response, so that any ID decodes
+	var msg struct {
+		response
+		ID json.RawMessage
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&msg); err != nil {
+		return response{}, err
+	}
+	res := msg.response
+	res.RawID = msg.ID
+	res.ID, _ = strconv.Atoi(string(msg.ID))
+
+	var rawResult struct {
+		Result json.RawMessage
+	}
+	if err := json.Unmarshal(raw, &rawResult); err != nil {
 		return response{}, err
 	}
+	if string(rawResult.Result) != "null" {
+		res.RawResult = rawResult.Result
+	}
+
+	return res, nil
+}
+
+// runRaw sends the request bytes as is and returns the bytes of the
+// response exactly as sent by the server.
+func (c *connection) runRaw(req []byte) ([]byte, error) {
+	if _, err := c.conn.Write(append(req, '\n')); err != nil {
+		return nil, err
+	}
+
+	var res json.RawMessage
+	if err := c.dec.Decode(&res); err != nil {
+		return nil, err
+	}
 
 	return res, nil
 }
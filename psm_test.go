@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestDecodeResponse(t *testing.T) {
+	raw := `{"id": 1, "result": {"z": 288230376151715606, "a": 1.50, "m": [1e3]}, "error": {"code": 0, "message": ""}}`
+
+	res, err := decodeResponse([]byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if exp := `{"z": 288230376151715606, "a": 1.50, "m": [1e3]}`; string(res.RawResult) != exp {
+		t.Errorf("Incorrect raw result:\n\t%s\n\t%s", res.RawResult, exp)
+	}
+
+	obj, ok := res.Result.(map[string]interface{})
+	if !ok {
+		t.Fatalf("Incorrect result type %T", res.Result)
+	}
+	if obj["z"] != json.Number("288230376151715606") {
+		t.Errorf("Incorrect large integer %v", obj["z"])
+	}
+
+	var buf bytes.Buffer
+	printResponse(&buf, res, jsonRenderer{format: formatRaw})
+	if exp := `{"z": 288230376151715606, "a": 1.50, "m": [1e3]}` + "\n"; buf.String() != exp {
+		t.Errorf("Incorrect raw output:\n\t%q\n\t%q", buf.String(), exp)
+	}
+
+	res, err = decodeResponse([]byte(`{"id": 2, "result": null, "error": {"code": -1, "message": "failed"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.RawResult != nil || res.Error.Code != -1 {
+		t.Errorf("Incorrect error response %+v", res)
+	}
+
+	res, err = decodeResponse([]byte(`{"id": "x", "result": 1, "error": {"code": 0, "message": ""}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res.RawID) != `"x"` || res.ID != 0 {
+		t.Errorf("Incorrect string ID response %+v", res)
+	}
+}
+
+func TestSendEncodeError(t *testing.T) {
+	var methods []string
+	s, _ := testSession(recordingHandler(&methods))
+
+	// Nothing is sent for a command that can't be encoded, so the
+	// connection can still be used
+	_, err := s.conn.run(command{ID: 1, Method: "a.ok", Params: []interface{}{json.Number("+5")}})
+	if _, ok := err.(encodeError); !ok {
+		t.Fatalf("Unexpected error %v, expected an encode error", err)
+	}
+	res, err := s.conn.run(command{ID: 2, Method: "b.ok"})
+	if err != nil || res.ID != 2 {
+		t.Errorf("Incorrect response after encode error: %+v, %v", res, err)
+	}
+	if len(methods) != 1 || methods[0] != "b.ok" {
+		t.Errorf("Incorrect methods %q != expected [b.ok]", methods)
+	}
+}
@@ -0,0 +1,55 @@
+// psmcli
+// Copyright (C) 2014 Procera Networks, Inc.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// isRaw returns whether the line is a raw request, which is sent without
+// expanding variables.
+func isRaw(line string) bool {
+	return line == "raw" || strings.HasPrefix(line, "raw ") || strings.HasPrefix(line, "{")
+}
+
+// sameID returns whether the IDs are the same JSON value, ignoring
+// formatting.
+func sameID(a, b json.RawMessage) bool {
+	var ca, cb bytes.Buffer
+	if json.Compact(&ca, a) != nil || json.Compact(&cb, b) != nil {
+		return false
+	}
+	return bytes.Equal(ca.Bytes(), cb.Bytes())
+}
+
+// rawRequest checks that the request is a JSON object and returns it
+// unchanged, except that an "id" member is inserted first in the object if
+// there isn't one already.
+func rawRequest(req string, id int) ([]byte, error) {
+	req = strings.TrimSpace(req)
+	if !strings.HasPrefix(req, "{") {
+		return nil, errors.New("raw request must be a JSON object")
+	}
+
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(req), &members); err != nil {
+		return nil, err
+	}
+	if _, ok := members["id"]; ok {
+		return []byte(req), nil
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(`{"id":`)
+	buf.WriteString(strconv.Itoa(id))
+	if len(members) > 0 {
+		buf.WriteString(",")
+	}
+	buf.WriteString(req[1:])
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"testing"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+func TestRawRequest(t *testing.T) {
+	testcases := []struct {
+		req string
+		res string
+	}{
+		{`{"method": "system.hostname"}`, `{"id":3,"method": "system.hostname"}`},
+		{` {"id": "x",  "method":"system.hostname"} `, `{"id": "x",  "method":"system.hostname"}`},
+		{`{}`, `{"id":3}`},
+		{`{ "params": [288230376151715606, 1.50] }`, `{"id":3, "params": [288230376151715606, 1.50] }`},
+	}
+
+	for _, tc := range testcases {
+		res, err := rawRequest(tc.req, 3)
+		if err != nil {
+			t.Errorf("Unexpected error for %s: %v", tc.req, err)
+			continue
+		}
+		if string(res) != tc.res {
+			t.Errorf("Incorrect request for %s:\n\t%s\n\t%s", tc.req, res, tc.res)
+		}
+	}
+
+	for _, req := range []string{``, `[]`, `{"method": }`, `{"a": 1} x`} {
+		if _, err := rawRequest(req, 3); err == nil {
+			t.Errorf("Unexpected nil error for %q", req)
+		}
+	}
+}
+
+func TestRawRunLine(t *testing.T) {
+	// The server echoes the request ID, which may be a string
+	client, server := net.Pipe()
+	go func() {
+		defer server.Close()
+		dec := json.NewDecoder(server)
+		for {
+			var req struct{ ID json.RawMessage }
+			if err := dec.Decode(&req); err != nil {
+				return
+			}
+			fmt.Fprintf(server, `{"id": %s, "result": "$b", "error": {"code": 0, "message": ""}}`+"\n", req.ID)
+		}
+	}()
+	dec := json.NewDecoder(client)
+	dec.UseNumber()
+	var out bytes.Buffer
+	s := &session{
+		conn:     &connection{conn: client, enc: json.NewEncoder(client), dec: dec},
+		out:      &out,
+		esc:      &terminal.EscapeCodes{},
+		renderer: jsonRenderer{format: formatRaw},
+		vars:     make(map[string]interface{}),
+	}
+
+	// Variables aren't expanded in raw requests
+	for _, line := range []string{`raw {"id": "$a", "method": "x.y"}`, `{"id": 7, "method": "x.y", "params": ["$b"]}`} {
+		_, ok, err := s.runLine(line, false)
+		if err != nil || !ok {
+			t.Errorf("Unexpected failure for %s: %v %v\n%s", line, ok, err, &out)
+		}
+	}
+	if s.vars["_"] != "$b" {
+		t.Errorf("Incorrect result %v", s.vars["_"])
+	}
+}
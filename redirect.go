@@ -0,0 +1,124 @@
+// psmcli
+// Copyright (C) 2014 Procera Networks, Inc.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// A redirect describes where the output of a command line should go instead
+// of the terminal; a file (possibly appended to) or a shell pipeline.
+type redirect struct {
+	File   string
+	Append bool
+	Pipe   string
+}
+
+func (r redirect) isSet() bool {
+	return r.File != "" || r.Pipe != ""
+}
+
+// splitRedirect splits a trailing "> file", ">> file" or "| shell command"
+// off the line. Operators are only recognized when they start a word outside
+// of quotes, JSON objects and parenthesised expressions, so LDAP filters like
+// (|(a=1)(b>=2)) are left alone. As in parseCommand, quotes and backslashes
+// have no special meaning within parentheses.
+func splitRedirect(line string) (string, redirect, error) {
+	depth, parens := 0, 0
+	var quote rune
+	escaped := false
+	prev := ' '
+
+	for i, r := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case parens > 0 && r == '(':
+			parens++
+		case parens > 0 && r == ')':
+			parens--
+		case parens > 0:
+		case r == '\\' && quote != '\'':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '(' && depth == 0:
+			parens++
+		case r == '{' || r == '[' || r == '(':
+			depth++
+		case r == '}' || r == ']' || r == ')':
+			depth--
+		case depth == 0 && isSpace(prev) && (r == '>' || r == '|'):
+			return parseRedirect(strings.TrimSpace(line[:i]), line[i:])
+		}
+		prev = r
+	}
+
+	return line, redirect{}, nil
+}
+
+func parseRedirect(line, op string) (string, redirect, error) {
+	var r redirect
+	switch {
+	case strings.HasPrefix(op, "|"):
+		r.Pipe = strings.TrimSpace(op[1:])
+		if r.Pipe == "" {
+			return "", redirect{}, errors.New("missing command after |")
+		}
+		return line, r, nil
+
+	case strings.HasPrefix(op, ">>"):
+		r.Append = true
+		r.File = strings.TrimSpace(op[2:])
+
+	default:
+		r.File = strings.TrimSpace(op[1:])
+	}
+
+	if r.File == "" {
+		return "", redirect{}, errors.New("missing file name after >")
+	}
+	if strings.IndexFunc(r.File, isSpace) >= 0 {
+		return "", redirect{}, errors.New("unexpected text after file name " + strings.Fields(r.File)[0])
+	}
+	return line, r, nil
+}
+
+// write sends the output to the file or pipeline. The terminal is switched
+// to the cooked state while the pipeline runs.
+func (r redirect) write(bs []byte, fd int, cooked *terminal.State) error {
+	if r.Pipe != "" {
+		return withState(fd, cooked, func() error {
+			cmd := exec.Command("/bin/sh", "-c", r.Pipe)
+			cmd.Stdin = bytes.NewReader(bs)
+			cmd.Stdout = os.Stdout
+			cmd.Stderr = os.Stderr
+			return cmd.Run()
+		})
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if r.Append {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	f, err := os.OpenFile(r.File, flags, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(bs); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+func TestSplitRedirect(t *testing.T) {
+	testcases := []struct {
+		line  string
+		rest  string
+		redir redirect
+	}{
+		{"system hostname", "system hostname", redirect{}},
+		{"subscriber list 5000 > subs.json", "subscriber list 5000", redirect{File: "subs.json"}},
+		{"subscriber list 5000 >> subs.json", "subscriber list 5000", redirect{File: "subs.json", Append: true}},
+		{"subscriber list 5000 >subs.json", "subscriber list 5000", redirect{File: "subs.json"}},
+		{"subscriber list 5000 | grep syno | wc -l", "subscriber list 5000", redirect{Pipe: "grep syno | wc -l"}},
+		{"object list subscriber (|(a=1)(b>=2))", "object list subscriber (|(a=1)(b>=2))", redirect{}},
+		{"object update subscriber 1234 a>b", "object update subscriber 1234 a>b", redirect{}},
+		{`object update subscriber 1234 'a > b' "c | d" e\ \>\ f > out`, `object update subscriber 1234 'a > b' "c | d" e\ \>\ f`, redirect{File: "out"}},
+		{"object list subscriber (cn=O'Brien) | wc", "object list subscriber (cn=O'Brien)", redirect{Pipe: "wc"}},
+		{`object update subscriber 1234 {"a": " > b", "c": "\" | d"} > out`, `object update subscriber 1234 {"a": " > b", "c": "\" | d"}`, redirect{File: "out"}},
+	}
+
+	for _, tc := range testcases {
+		rest, redir, err := splitRedirect(tc.line)
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %v", tc.line, err)
+			continue
+		}
+		if rest != tc.rest || redir != tc.redir {
+			t.Errorf("Incorrect split of %q:\n\t%q %+v\n\t%q %+v", tc.line, rest, redir, tc.rest, tc.redir)
+		}
+	}
+
+	for _, line := range []string{"system hostname >", "system hostname |", "system hostname > a b"} {
+		if _, _, err := splitRedirect(line); err == nil {
+			t.Errorf("Unexpected nil error for %q", line)
+		}
+	}
+}
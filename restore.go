@@ -0,0 +1,410 @@
+// psmcli
+// Copyright (C) 2014 Procera Networks, Inc.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// The policies for objects that already exist on the target node.
+const (
+	policySkip      = "skip"
+	policyOverwrite = "overwrite"
+	policyFail      = "fail"
+)
+
+// existingObjects returns the OIDs of the objects of the types on the
+// node, by type and application ID. Types the node can't list are left
+// out.
+func (s *session) existingObjects(types []string) (map[string]map[string]interface{}, bool, error) {
+	existing := make(map[string]map[string]interface{})
+	for _, typ := range types {
+		if _, ok := s.services[listMethod(typ)]; !ok && len(s.services) > 0 {
+			continue
+		}
+		oids := make(map[string]interface{})
+		ok, err := s.listObjects(typ, defaultPageSize, func(obj map[string]interface{}) error {
+			if aid := objectAid(typ, obj); aid != "" {
+				oids[aid] = obj["oid"]
+			}
+			return nil
+		})
+		if err != nil || !ok {
+			return nil, false, err
+		}
+		existing[typ] = oids
+	}
+	return existing, true, nil
+}
+
+// typeCount counts the objects of a type to be transferred.
+type typeCount struct {
+	typ      string
+	total    int
+	existing int
+}
+
+// preflight prints the number of new and existing objects of each type,
+// and the types the target doesn't support. It returns the number of
+// objects that already exist.
+func preflight(w io.Writer, objs []typedObject, existing map[string]map[string]interface{}, policy string) int {
+	counts := make(map[string]*typeCount)
+	var types []string
+	for _, o := range objs {
+		c, ok := counts[o.Type]
+		if !ok {
+			c = &typeCount{typ: o.Type}
+			counts[o.Type] = c
+			types = append(types, o.Type)
+		}
+		c.total++
+		if aid := objectAid(o.Type, o.Object); aid != "" {
+			if _, ok := existing[o.Type][aid]; ok {
+				c.existing++
+			}
+		}
+	}
+	sort.Strings(types)
+
+	conflicts := 0
+	for _, typ := range types {
+		c := counts[typ]
+		if _, ok := existing[typ]; !ok {
+			fmt.Fprintf(w, "  %s: %d objects, not supported by the target\n", typ, c.total)
+			continue
+		}
+		fmt.Fprintf(w, "  %s: %d objects, %d new", typ, c.total, c.total-c.existing)
+		if c.existing > 0 {
+			fmt.Fprintf(w, ", %d existing (%s)", c.existing, policy)
+		}
+		fmt.Fprintln(w)
+		conflicts += c.existing
+	}
+	return conflicts
+}
+
+// transferResult counts what was done with the transferred objects.
+type transferResult struct {
+	created, updated, skipped, failed int
+}
+
+func (r transferResult) String() string {
+	return fmt.Sprintf("%d created, %d updated, %d skipped, %d failed", r.created, r.updated, r.skipped, r.failed)
+}
+
+// transfer creates the objects on the node, or updates or skips those that
+// already exist according to the policy. Server managed attributes are
+// left out and parentOid is changed to the OID on the node of the parent,
+// which is created first; if object.create doesn't return the OID, it's
+// looked up by application ID. The OIDs on the node of parents that aren't
+// among the objects are looked up in parents; objects whose parent isn't
+// found fail. With detail, each object is reported; otherwise the
+// progress and then the failures.
+func (s *session) transfer(objs []typedObject, existing map[string]map[string]interface{}, parents map[string]interface{}, policy string, detail bool) (transferResult, error) {
+	var res transferResult
+
+	byOid := make(map[string]int)
+	for i, o := range objs {
+		if oid, ok := o.Object["oid"]; ok && oid != nil {
+			byOid[plainString(oid)] = i
+		}
+	}
+	levels := parentLevels(objs, byOid)
+
+	// The OIDs on the node of the objects created, updated or skipped,
+	// for their children, and the objects created without getting their
+	// OID, which are looked up by application ID when needed
+	nodeOids := make(map[int]interface{})
+	unknown := make(map[int]bool)
+
+	var p *progress
+	if !detail {
+		p = newProgress(s.out, "done", len(objs))
+	}
+	var failures []string
+	report := func(i int, ok bool, format string, args ...interface{}) {
+		o := objs[i]
+		msg := fmt.Sprintf("%s %s: %s", o.Type, objectAid(o.Type, o.Object), fmt.Sprintf(format, args...))
+		switch {
+		case detail:
+			fmt.Fprintln(s.out, msg)
+		case !ok:
+			failures = append(failures, msg)
+		}
+		if p != nil {
+			p.done(ok)
+		}
+	}
+
+	for _, level := range levels {
+		for _, i := range level {
+			o := objs[i]
+			if _, ok := existing[o.Type]; !ok {
+				res.skipped++
+				report(i, true, "skipped, type not supported")
+				continue
+			}
+
+			attrs := make(attributes, len(o.Object))
+			for k, v := range o.Object {
+				attrs[k] = v
+			}
+			for _, f := range serverFields {
+				delete(attrs, f)
+			}
+			if parent, ok := attrs["parentOid"]; ok && parent != nil {
+				if j, ok := byOid[plainString(parent)]; ok {
+					if unknown[j] {
+						if err := s.lookupCreated(objs, objs[j].Type, unknown, nodeOids); err != nil {
+							return res, err
+						}
+					}
+					oid, ok := nodeOids[j]
+					if !ok {
+						res.failed++
+						name := objectAid(objs[j].Type, objs[j].Object)
+						if name == "" {
+							name = "oid " + plainString(parent)
+						}
+						report(i, false, "failed, parent %s %s is missing", objs[j].Type, name)
+						continue
+					}
+					attrs["parentOid"] = oid
+				} else if oid, ok := parents[plainString(parent)]; ok {
+					attrs["parentOid"] = oid
+				} else {
+					res.failed++
+					report(i, false, "failed, parent %s isn't among the objects or on the node", plainString(parent))
+					continue
+				}
+			}
+
+			aid := objectAid(o.Type, o.Object)
+			oid, exists := existing[o.Type][aid]
+			var cmd command
+			var err error
+			switch {
+			case !exists || aid == "":
+				cmd, err = objectCommand(s.services, methodCreate, o.Type, attrs)
+			case policy == policyOverwrite:
+				delete(attrs, aidAttribute(o.Type))
+				cmd, err = objectCommand(s.services, methodUpdateByAid, o.Type, aid, attrs)
+			default:
+				nodeOids[i] = oid
+				res.skipped++
+				report(i, true, "skipped, already exists")
+				continue
+			}
+			if err != nil {
+				res.failed++
+				report(i, false, "failed, %v", err)
+				continue
+			}
+
+			cmd.ID = s.id
+			s.id++
+			r, err := s.conn.run(cmd)
+			if _, ok := err.(encodeError); ok {
+				res.failed++
+				report(i, false, "failed, %v", err)
+				continue
+			}
+			if err != nil {
+				return res, err
+			}
+			switch {
+			case r.Error.Code != 0:
+				res.failed++
+				report(i, false, "failed, %s", r.Error.Message)
+			case cmd.Method == methodCreate:
+				if oid, ok := createdOid(r.Result); ok {
+					nodeOids[i] = oid
+				} else {
+					unknown[i] = true
+				}
+				res.created++
+				report(i, true, "created")
+			default:
+				nodeOids[i] = oid
+				res.updated++
+				report(i, true, "updated")
+			}
+		}
+	}
+
+	if p != nil {
+		p.finish()
+	}
+	for _, msg := range failures {
+		fmt.Fprintln(s.out, msg)
+	}
+	return res, nil
+}
+
+// lookupCreated finds the OIDs on the node of the created objects of the
+// type by application ID. They're no longer unknown, found or not.
+func (s *session) lookupCreated(objs []typedObject, typ string, unknown map[int]bool, nodeOids map[int]interface{}) error {
+	existing, ok, err := s.existingObjects([]string{typ})
+	if err != nil {
+		return err
+	}
+	for i := range unknown {
+		if objs[i].Type != typ {
+			continue
+		}
+		delete(unknown, i)
+		aid := objectAid(typ, objs[i].Object)
+		if oid, found := existing[typ][aid]; ok && found && aid != "" {
+			nodeOids[i] = oid
+		}
+	}
+	return nil
+}
+
+// orphans returns the number of objects whose parent isn't among the
+// objects.
+func orphans(objs []typedObject) int {
+	oids := make(map[string]bool)
+	for _, o := range objs {
+		if oid, ok := o.Object["oid"]; ok && oid != nil {
+			oids[plainString(oid)] = true
+		}
+	}
+	n := 0
+	for _, o := range objs {
+		if parent, ok := o.Object["parentOid"]; ok && parent != nil && !oids[plainString(parent)] {
+			n++
+		}
+	}
+	return n
+}
+
+// parentLevels returns the indexes of the objects ordered so that parents
+// come before their children; objects without parents among the objects
+// first, then their children, and so on.
+func parentLevels(objs []typedObject, byOid map[string]int) [][]int {
+	depth := make([]int, len(objs))
+	for i := range objs {
+		d, j := 0, i
+		for d <= len(objs) {
+			parent, ok := objs[j].Object["parentOid"]
+			if !ok || parent == nil {
+				break
+			}
+			if j, ok = byOid[plainString(parent)]; !ok {
+				break
+			}
+			d++
+		}
+		if d > len(objs) {
+			// A loop; let it fail on the missing parent
+			d = 0
+		}
+		depth[i] = d
+	}
+
+	var levels [][]int
+	for i, d := range depth {
+		for len(levels) <= d {
+			levels = append(levels, nil)
+		}
+		levels[d] = append(levels[d], i)
+	}
+	return levels
+}
+
+// restore restores the objects of the snapshot, after printing the
+// preflight report. Nothing is changed if there are existing objects and
+// the policy is to fail, or for a dry run.
+func (s *session) restore(hdr snapshotHeader, objs []typedObject, policy string, dryRun bool) (bool, error) {
+	version, err := s.callString("system.version")
+	if err != nil {
+		return false, err
+	}
+	hostname, err := s.callString("system.hostname")
+	if err != nil {
+		return false, err
+	}
+	fmt.Fprintf(s.out, "Restoring snapshot of %s (PSM %s) taken %s\n", hdr.Hostname, hdr.Version, hdr.Time)
+	fmt.Fprintf(s.out, "to %s (PSM %s)\n", hostname, version)
+	if version != hdr.Version {
+		fmt.Fprintln(s.out, "Warning: the PSM versions differ")
+	}
+
+	var types []string
+	seen := make(map[string]bool)
+	for _, o := range objs {
+		if !seen[o.Type] {
+			seen[o.Type] = true
+			types = append(types, o.Type)
+		}
+	}
+	existing, ok, err := s.existingObjects(types)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	conflicts := preflight(s.out, objs, existing, policy)
+	if n := orphans(objs); n > 0 {
+		fmt.Fprintf(s.out, "Warning: %d objects have parents that aren't in the snapshot and will fail\n", n)
+	}
+	if conflicts > 0 && policy == policyFail {
+		fmt.Fprintf(s.out, "%d objects already exist; nothing restored (use -policy=skip or -policy=overwrite)\n", conflicts)
+		return false, nil
+	}
+	if dryRun {
+		return true, nil
+	}
+
+	res, err := s.transfer(objs, existing, nil, policy, false)
+	if err != nil {
+		return false, err
+	}
+	fmt.Fprintln(s.out, res)
+	return res.failed == 0, nil
+}
+
+// restoreMain runs "psmcli restore", restoring a snapshot onto the node.
+func restoreMain(args []string, user string, verbose bool) int {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	policy := fs.String("policy", policyFail, "What to do with objects that already exist: skip, overwrite or fail")
+	dryRun := fs.Bool("dry-run", false, "Only print the preflight report")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "Usage: psmcli [options] restore [-policy=skip|overwrite|fail] [-dry-run] <host:port | profile> <snapshot | ->")
+		return 2
+	}
+	switch *policy {
+	case policySkip, policyOverwrite, policyFail:
+	default:
+		fmt.Fprintln(os.Stderr, "Unknown policy; use skip, overwrite or fail")
+		return 2
+	}
+
+	hdr, objs, err := readSnapshotFile(fs.Arg(1))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	s, err := connectBatch(fs.Arg(0), user, os.Stdout, verbose)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	ok, err := s.restore(hdr, objs, *policy, *dryRun)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if !ok {
+		return 1
+	}
+	return 0
+}
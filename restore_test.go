@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParentLevels(t *testing.T) {
+	objs := []typedObject{
+		{"subscriber", map[string]interface{}{"oid": json.Number("3"), "parentOid": json.Number("2")}},
+		{"group", map[string]interface{}{"oid": json.Number("2"), "parentOid": json.Number("1")}},
+		{"group", map[string]interface{}{"oid": json.Number("1"), "parentOid": nil}},
+		{"subscriber", map[string]interface{}{"oid": json.Number("4"), "parentOid": json.Number("99")}},
+		{"group", map[string]interface{}{"oid": json.Number("5"), "parentOid": json.Number("6")}},
+		{"group", map[string]interface{}{"oid": json.Number("6"), "parentOid": json.Number("5")}},
+	}
+	byOid := map[string]int{"3": 0, "2": 1, "1": 2, "4": 3, "5": 4, "6": 5}
+
+	levels := parentLevels(objs, byOid)
+	exp := [][]int{{2, 3, 4, 5}, {1}, {0}}
+	if !reflect.DeepEqual(levels, exp) {
+		t.Errorf("Incorrect levels %v != expected %v", levels, exp)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	hdr := snapshotHeader{Format: 1, Hostname: "psm1", Version: "15.0"}
+	objs := []typedObject{
+		{"subscriber", map[string]interface{}{"oid": json.Number("1"), "subscriberId": "a", "parentOid": json.Number("10"), "updateTime": "x"}},
+		{"subscriber", map[string]interface{}{"oid": json.Number("2"), "subscriberId": "b", "parentOid": json.Number("10")}},
+		{"subscriber", map[string]interface{}{"oid": json.Number("3"), "subscriberId": "c", "parentOid": json.Number("20")}},
+		{"group", map[string]interface{}{"oid": json.Number("10"), "aid": "g1", "creationTime": "x"}},
+		{"group", map[string]interface{}{"oid": json.Number("20"), "name": "no aid"}},
+	}
+
+	testcases := []struct {
+		policy string
+		ok     bool
+		calls  []string
+	}{
+		{policyFail, false, nil},
+		// The groups get OIDs 1 and 2 on the target, as returned by
+		// object.create, also for the one without an application ID.
+		{policySkip, true, []string{
+			"object.create [group map[aid:g1]]",
+			"object.create [group map[name:no aid]]",
+			"object.create [subscriber map[parentOid:1 subscriberId:b]]",
+			"object.create [subscriber map[parentOid:2 subscriberId:c]]",
+		}},
+		{policyOverwrite, true, []string{
+			"object.create [group map[aid:g1]]",
+			"object.create [group map[name:no aid]]",
+			"object.updateByAid [subscriber a map[parentOid:1]]",
+			"object.create [subscriber map[parentOid:1 subscriberId:b]]",
+			"object.create [subscriber map[parentOid:2 subscriberId:c]]",
+		}},
+	}
+
+	for _, tc := range testcases {
+		f := &fakePSM{hostname: "psm2", objects: map[string][]map[string]interface{}{
+			"subscriber": {{"oid": json.Number("500"), "subscriberId": "a"}},
+			"group":      {},
+		}}
+		s, out := testSession(f.handle)
+
+		ok, err := s.restore(hdr, objs, tc.policy, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != tc.ok {
+			t.Errorf("Incorrect result for policy %s: %v != expected %v\n%s", tc.policy, ok, tc.ok, out)
+		}
+		if !reflect.DeepEqual(f.calls, tc.calls) {
+			t.Errorf("Incorrect calls for policy %s:\n%q\n!= expected\n%q", tc.policy, f.calls, tc.calls)
+		}
+
+		report := "  group: 2 objects, 2 new\n  subscriber: 3 objects, 2 new, 1 existing (" + tc.policy + ")\n"
+		if !strings.Contains(out.String(), report) {
+			t.Errorf("Missing preflight report for policy %s in output:\n%s", tc.policy, out)
+		}
+	}
+}
+
+func TestRestoreOrphans(t *testing.T) {
+	// An object whose parent isn't in the snapshot fails instead of
+	// losing its parent
+	objs := []typedObject{
+		{"subscriber", map[string]interface{}{"oid": json.Number("1"), "subscriberId": "a", "parentOid": json.Number("99")}},
+		{"subscriber", map[string]interface{}{"oid": json.Number("2"), "subscriberId": "b"}},
+	}
+	f := &fakePSM{objects: map[string][]map[string]interface{}{"subscriber": {}}}
+	s, out := testSession(f.handle)
+
+	ok, err := s.restore(snapshotHeader{Format: 1}, objs, policyFail, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Errorf("Unexpected success restoring an object without its parent\n%s", out)
+	}
+	exp := []string{"object.create [subscriber map[subscriberId:b]]"}
+	if !reflect.DeepEqual(f.calls, exp) {
+		t.Errorf("Incorrect calls:\n%q\n!= expected\n%q", f.calls, exp)
+	}
+	for _, exp := range []string{"Warning: 1 objects have parents that aren't in the snapshot", "subscriber a: failed, parent 99"} {
+		if !strings.Contains(out.String(), exp) {
+			t.Errorf("Missing %q in output:\n%s", exp, out)
+		}
+	}
+}
+
+func TestRestoreCreateResult(t *testing.T) {
+	// The OIDs of created parents are looked up by application ID when
+	// object.create doesn't return them; parents without one fail their
+	// children
+	objs := []typedObject{
+		{"subscriber", map[string]interface{}{"oid": json.Number("1"), "subscriberId": "a", "parentOid": json.Number("10")}},
+		{"subscriber", map[string]interface{}{"oid": json.Number("2"), "subscriberId": "b", "parentOid": json.Number("20")}},
+		{"group", map[string]interface{}{"oid": json.Number("10"), "aid": "g1"}},
+		{"group", map[string]interface{}{"oid": json.Number("20"), "name": "no aid"}},
+	}
+	f := &fakePSM{nextOid: 40, createTrue: true, objects: map[string][]map[string]interface{}{
+		"subscriber": {},
+		"group":      {},
+	}}
+	s, out := testSession(f.handle)
+
+	ok, err := s.restore(snapshotHeader{Format: 1}, objs, policyFail, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Errorf("Unexpected success restoring a child of a parent without application ID\n%s", out)
+	}
+	exp := []string{
+		"object.create [group map[aid:g1]]",
+		"object.create [group map[name:no aid]]",
+		"object.create [subscriber map[parentOid:41 subscriberId:a]]",
+	}
+	if !reflect.DeepEqual(f.calls, exp) {
+		t.Errorf("Incorrect calls:\n%q\n!= expected\n%q", f.calls, exp)
+	}
+	if !strings.Contains(out.String(), "subscriber b: failed, parent group oid 20 is missing") {
+		t.Errorf("Missing failure in output:\n%s", out)
+	}
+}
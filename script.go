@@ -0,0 +1,206 @@
+// psmcli
+// Copyright (C) 2014 Procera Networks, Inc.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh/terminal"
+	"kastelo.io/psmcli/completion"
+)
+
+// runScriptFile runs the commands in the named script file, or standard
+// input for "-", after the startup scripts unless noInit is set. It
+// returns the exit code for psmcli.
+func runScriptFile(conn *connection, name string, login bool, user, profile string, noInit bool, renderer jsonRenderer, verbose bool) int {
+	var in io.Reader = os.Stdin
+	if name != "-" {
+		fd, err := os.Open(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer fd.Close()
+		in = fd
+	}
+
+	s, err := batchSession(conn, login, user, os.Stdout, renderer, verbose)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := s.loadConfig(filepath.Join(configDir(), "config")); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if !noInit {
+		if err := s.runInit(profile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	code, err := s.runScript(in)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return code
+}
+
+// batchSession returns a session for running commands without a terminal,
+// logging in first if required.
+func batchSession(conn *connection, login bool, user string, out io.Writer, renderer jsonRenderer, verbose bool) (*session, error) {
+	if login {
+		if err := scriptLogin(conn, user); err != nil {
+			return nil, err
+		}
+	}
+
+	smd, err := conn.smd()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &session{
+		conn:      conn,
+		services:  smd.Result.Services,
+		completer: completion.NewCallbackCompleter(importSMD(smd.Result.Services)...),
+		out:       out,
+		esc:       &terminal.EscapeCodes{},
+		renderer:  renderer,
+		verbose:   verbose,
+		vars:      make(map[string]interface{}),
+	}
+	s.completer.Commands = s.aliasMatchers
+	return s, nil
+}
+
+// connectBatch connects to the destination, a host[:port] or profile name,
+// and returns a session for running commands without a terminal.
+// Messages are written to out.
+func connectBatch(dst, user string, out io.Writer, verbose bool) (*session, error) {
+	profiles, err := readProfiles(filepath.Join(configDir(), "config"))
+	if err != nil {
+		return nil, err
+	}
+	addr, p, err := resolveDestination(dst, profiles)
+	if err != nil {
+		return nil, err
+	}
+	if user == "" {
+		user = p.user
+	}
+
+	conn, err := newConnection(addr)
+	if err != nil {
+		return nil, err
+	}
+	res, err := conn.run(command{Method: "system.version"})
+	if err != nil {
+		return nil, err
+	}
+
+	login := res.Error.Code == CodeAccessDenied
+	return batchSession(conn, login, user, out, jsonRenderer{format: formatJSON}, verbose)
+}
+
+// scriptLogin logs in as the given user, with the password taken from
+// $PSMCLI_PASSWORD or read from the terminal.
+func scriptLogin(conn *connection, user string) error {
+	if user == "" {
+		return errors.New("PSM requires authentication; give the user name with -user")
+	}
+
+	pass := os.Getenv("PSMCLI_PASSWORD")
+	if pass == "" && terminal.IsTerminal(0) {
+		fmt.Fprint(os.Stderr, "Password: ")
+		bs, err := terminal.ReadPassword(0)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return err
+		}
+		pass = string(bs)
+	}
+
+	res, err := conn.run(command{Method: "system.login", Params: []interface{}{user, pass}})
+	if err != nil {
+		return err
+	}
+	if res.Error.Code != 0 {
+		return errors.New(res.Error.Message)
+	}
+	return nil
+}
+
+// runScript runs the script read from r and returns the exit code; that
+// given to exit, or 1 if an expect statement failed or the last command
+// failed and 0 otherwise. Syntax errors in the script give exit code 2.
+// Commands are run as if they were entered in the REPL. Empty lines and
+// lines starting with # are ignored.
+func (s *session) runScript(r io.Reader) (int, error) {
+	s.failures = 0
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 16<<20)
+
+	var lines []scriptLine
+	line, start, num := "", 0, 0
+	for sc.Scan() {
+		num++
+		text := sc.Text()
+		if line == "" {
+			if t := strings.TrimSpace(text); t == "" || strings.HasPrefix(t, "#") {
+				continue
+			}
+			start = num
+		}
+
+		cont, more := continues(line + text)
+		if more {
+			if cont == line+text {
+				// Within quotes or JSON the line break is kept
+				cont += "\n"
+			}
+			line = cont
+			continue
+		}
+
+		lines = append(lines, scriptLine{num: start, text: strings.TrimSpace(cont)})
+		line = ""
+	}
+	if err := sc.Err(); err != nil {
+		return 1, err
+	}
+
+	if line != "" {
+		fmt.Fprintf(s.out, "line %d: incomplete command at end of script\n", start)
+		return 2, nil
+	}
+
+	stmts, err := parseScript(lines)
+	if err != nil {
+		fmt.Fprintln(s.out, err)
+		return 2, nil
+	}
+
+	ok, err := s.runBlock(stmts)
+	if code, isExit := err.(scriptExit); isExit {
+		return int(code), nil
+	}
+	if err != nil && err != errReturn {
+		return 1, err
+	}
+
+	if s.failures > 0 || !ok {
+		return 1, nil
+	}
+	return 0, nil
+}
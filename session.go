@@ -0,0 +1,421 @@
+// psmcli
+// Copyright (C) 2014 Procera Networks, Inc.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/ssh/terminal"
+	"kastelo.io/psmcli/completion"
+)
+
+// A session executes command lines against a connected and logged in PSM,
+// printing the results on the terminal.
+type session struct {
+	conn      *connection
+	services  map[string]smdService
+	completer *completion.CallbackCompleter
+	out       io.Writer             // the terminal, or standard output for scripts
+	esc       *terminal.EscapeCodes // escape codes for help output
+	pager     *pager                // for long output, or nil to write it directly
+	cooked    *terminal.State       // the terminal state to use while running shell commands
+	renderer  jsonRenderer
+	verbose   bool
+	id        int
+	vars      map[string]interface{}
+	maxCalls  int // limit on mutating calls set by foreach, or zero
+	calls     int // mutating calls made against the limit
+	funcs     map[string]statement
+	aliases   map[string]alias
+	expanding map[string]bool              // aliases being run, which aren't expanded again
+	attrTypes map[string]map[string]string // attribute types by object type
+	failures  int                          // failed expect statements
+}
+
+var setExp = regexp.MustCompile(`^set\s+([A-Za-z_][A-Za-z0-9_]*)\s*=\s*(.*)$`)
+
+// execute runs the command line, which may be several commands chained
+// with ";", "&&" and "||". It returns whether the last command run
+// succeeded. The returned error is a failure of the connection to PSM,
+// after which the session can't continue; other errors are printed.
+func (s *session) execute(line string) (bool, error) {
+	if strings.HasPrefix(strings.TrimSpace(line), "foreach ") {
+		// The loop body is the rest of the line, including any
+		// chained commands
+		return s.foreach(strings.TrimSpace(line))
+	}
+	if def := strings.TrimSpace(line); aliasExp.MatchString(def) || macroExp.MatchString(def) {
+		// The body is the rest of the line, including any chained
+		// commands
+		_, ok := s.define(def)
+		return ok, nil
+	}
+
+	parts, err := splitChain(line)
+	if err != nil {
+		fmt.Fprintln(s.out, err)
+		return false, nil
+	}
+
+	ok := true
+	for i, part := range parts {
+		if i > 0 && (part.op == "&&" && !ok || part.op == "||" && ok) {
+			// Skipped; the status of the previous command remains
+			continue
+		}
+		if ok, err = s.executeCommand(part.line); err != nil {
+			return false, err
+		}
+	}
+	return ok, nil
+}
+
+// executeCommand runs a single command, returning whether it succeeded.
+func (s *session) executeCommand(line string) (bool, error) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return true, nil
+	}
+
+	// alias, macro and unalias are handled before aliases are expanded
+
+	if handled, ok := s.define(line); handled {
+		return ok, nil
+	}
+
+	// Aliases and macros may expand to several chained commands
+
+	if expanded, ok, err := s.expandAlias(line); err != nil {
+		fmt.Fprintln(s.out, err)
+		return false, nil
+	} else if ok {
+		if s.expanding == nil {
+			s.expanding = make(map[string]bool)
+		}
+		name, _ := firstWord(line)
+		s.expanding[name] = true
+		defer delete(s.expanding, name)
+		return s.execute(expanded)
+	}
+
+	// "set name = command" captures the result of the command in a
+	// variable instead of printing it.
+
+	if m := setExp.FindStringSubmatch(line); m != nil {
+		if m[2] == "" {
+			fmt.Fprintln(s.out, "missing command to set", m[1], "from")
+			return false, nil
+		}
+		v, ok, err := s.evaluate(m[2])
+		if ok {
+			s.vars[m[1]] = v
+		}
+		return ok, err
+	}
+
+	if name, args := firstWord(line); s.funcs != nil {
+		if f, ok := s.funcs[name]; ok {
+			return s.call(f, args)
+		}
+	}
+
+	// The copy selector may compare with < and >, which would otherwise
+	// be taken as redirections
+
+	if name, args := firstWord(line); name == "copy" {
+		args, err := expandVars(args, s.vars)
+		if err != nil {
+			fmt.Fprintln(s.out, err)
+			return false, nil
+		}
+		return s.copyCmd(args)
+	}
+
+	_, ok, err := s.runLine(line, false)
+	return ok, err
+}
+
+// evaluate returns the value of the variable reference, e.g. $_.oid, or
+// the result of the command.
+func (s *session) evaluate(line string) (interface{}, bool, error) {
+	v, ok, err := lookupVar(line, s.vars)
+	if err != nil {
+		fmt.Fprintln(s.out, err)
+		return nil, false, nil
+	}
+	if ok {
+		return v, true, nil
+	}
+
+	line, _, err = s.expandAlias(line)
+	if err != nil {
+		fmt.Fprintln(s.out, err)
+		return nil, false, nil
+	}
+	return s.runLine(line, true)
+}
+
+// runLine runs the command and prints the result, or returns it if capture
+// is set. Errors are always printed.
+func (s *session) runLine(line string, capture bool) (interface{}, bool, error) {
+	var err error
+	if !isRaw(line) {
+		line, err = expandVars(line, s.vars)
+		if err != nil {
+			fmt.Fprintln(s.out, err)
+			return nil, false, nil
+		}
+	}
+
+	line, redir, err := splitRedirect(line)
+	if err != nil {
+		fmt.Fprintln(s.out, err)
+		return nil, false, nil
+	}
+
+	// A "| where condition" filters the result list instead of being
+	// run as a shell command.
+
+	filter := ""
+	if strings.HasPrefix(redir.Pipe, "where ") {
+		filter, redir = strings.TrimPrefix(redir.Pipe, "where "), redirect{}
+	}
+
+	// Output goes to the terminal, through the pager when it's long,
+	// or uncolored to the redirection target.
+
+	esc, rend, show := s.esc, s.renderer, s.show
+	if redir.isSet() {
+		esc, rend = &terminal.EscapeCodes{}, jsonRenderer{theme: s.renderer.theme, format: s.renderer.format}
+		show = func(bs []byte) error {
+			return redir.write(bs, 0, s.cooked)
+		}
+	}
+	output := func(fn func(*bytes.Buffer)) bool {
+		var buf bytes.Buffer
+		fn(&buf)
+		if err := show(buf.Bytes()); err != nil {
+			fmt.Fprintln(s.out, err)
+			return false
+		}
+		return true
+	}
+
+	if line == "help" || line == "?" {
+		return nil, output(func(buf *bytes.Buffer) {
+			printHelp(buf, esc)
+		}), nil
+	}
+	if line == "format" || strings.HasPrefix(line, "format ") {
+		switch f := strings.TrimSpace(strings.TrimPrefix(line, "format")); f {
+		case "":
+		case formatJSON, formatHuman, formatRaw:
+			s.renderer.format = f
+		default:
+			fmt.Fprintln(s.out, "Unknown format; use json, human or raw")
+			return nil, false, nil
+		}
+		fmt.Fprintln(s.out, "Output format is", s.renderer.format)
+		return nil, true, nil
+	}
+	if line == "commands" {
+		return nil, output(func(buf *bytes.Buffer) {
+			s.completer.PrintHelp(buf, esc)
+		}), nil
+	}
+	if line == "set" {
+		return nil, output(func(buf *bytes.Buffer) {
+			s.printVars(buf, rend)
+		}), nil
+	}
+	if line == "import" || strings.HasPrefix(line, "import ") {
+		ok, err := s.importFile(strings.TrimPrefix(line, "import"))
+		return nil, ok, err
+	}
+	if line == "export" || strings.HasPrefix(line, "export ") {
+		ok, err := s.exportFile(strings.TrimPrefix(line, "export"))
+		return nil, ok, err
+	}
+
+	// Raw JSON-RPC requests are sent as given and the response
+	// printed exactly as received.
+
+	if isRaw(line) {
+		if filter != "" {
+			fmt.Fprintln(s.out, "where can't be used with raw requests")
+			return nil, false, nil
+		}
+
+		req, err := rawRequest(strings.TrimPrefix(line, "raw"), s.id)
+		if err != nil {
+			fmt.Fprintln(s.out, err)
+			return nil, false, nil
+		}
+		s.id++
+
+		var request struct {
+			ID     json.RawMessage
+			Method string
+		}
+		json.Unmarshal(req, &request)
+		if !s.allowCall(request.Method) {
+			return nil, false, nil
+		}
+
+		if s.verbose {
+			fmt.Fprintf(s.out, "> %s\n", req)
+		}
+
+		bs, err := s.conn.runRaw(req)
+		if err != nil {
+			return nil, false, err
+		}
+
+		res, err := decodeResponse(bs)
+		ok := err == nil && res.Error.Code == 0
+		if err == nil && !sameID(res.RawID, request.ID) {
+			fmt.Fprintf(s.out, "Response ID %s doesn't match request ID %s\n", res.RawID, request.ID)
+			ok = false
+		}
+		if ok {
+			s.vars["_"] = res.Result
+		}
+		if !capture {
+			ok = output(func(buf *bytes.Buffer) {
+				rend.write(buf, bs)
+				fmt.Fprintln(buf)
+			}) && ok
+		} else if !ok {
+			s.out.Write(bs)
+			fmt.Fprintln(s.out)
+		}
+		return res.Result, ok, nil
+	}
+
+	// A leading ! sends the command as given, without converting or
+	// checking it against the SMD.
+
+	force := strings.HasPrefix(line, "!")
+	if force {
+		line = strings.TrimSpace(line[1:])
+	}
+
+	cmd, err := parseCommand(line)
+	if err != nil {
+		fmt.Fprintln(s.out, err)
+		return nil, false, nil
+	}
+	if err := resolveNamed(&cmd, s.services); err != nil {
+		fmt.Fprintln(s.out, err)
+		return nil, false, nil
+	}
+	if !force {
+		types, err := s.commandAttributeTypes(cmd)
+		if err != nil {
+			return nil, false, err
+		}
+		if err := coerceParams(&cmd, s.services, types); err != nil {
+			fmt.Fprintln(s.out, err)
+			fmt.Fprintln(s.out, "(Prefix the command with ! to send it anyway)")
+			return nil, false, nil
+		}
+		if err := validateCommand(cmd, s.services); err != nil {
+			fmt.Fprintln(s.out, err)
+			fmt.Fprintln(s.out, "(Prefix the command with ! to send it anyway)")
+			return nil, false, nil
+		}
+	}
+	if !s.allowCall(cmd.Method) {
+		return nil, false, nil
+	}
+
+	cmd.ID = s.id
+	s.id++
+
+	if s.verbose {
+		// Print the command locally
+		bs, _ := json.Marshal(cmd)
+		fmt.Fprintf(s.out, "> %s\n", bs)
+	}
+
+	// Execute command on PSM
+
+	res, err := s.conn.run(cmd)
+	if _, ok := err.(encodeError); ok {
+		fmt.Fprintln(s.out, err)
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	if res.Error.Code != 0 && (redir.isSet() || capture) {
+		// Errors are shown on the terminal, not sent to the file or
+		// pipeline.
+		printResponse(s.out, res, s.renderer)
+		return nil, false, nil
+	}
+
+	if res.Error.Code == 0 && filter != "" {
+		if res.Result, err = where(res.Result, filter); err != nil {
+			fmt.Fprintln(s.out, err)
+			return nil, false, nil
+		}
+		res.RawResult, _ = json.Marshal(res.Result)
+	}
+
+	if res.Error.Code == 0 {
+		s.vars["_"] = res.Result
+	}
+	if capture {
+		return res.Result, true, nil
+	}
+	return res.Result, output(func(buf *bytes.Buffer) {
+		printResponse(buf, res, rend)
+	}) && res.Error.Code == 0, nil
+}
+
+// show writes output through the pager, if any.
+func (s *session) show(bs []byte) error {
+	if s.pager != nil {
+		return s.pager.show(bs)
+	}
+	_, err := s.out.Write(bs)
+	return err
+}
+
+// varNames returns the names of the defined variables, for completion.
+func (s *session) varNames() []string {
+	var names []string
+	for name := range s.vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// printVars prints each variable and its value.
+func (s *session) printVars(buf *bytes.Buffer, rend jsonRenderer) {
+	for _, name := range s.varNames() {
+		fmt.Fprintf(buf, "$%s = ", name)
+		bs, err := json.Marshal(s.vars[name])
+		if err != nil {
+			fmt.Fprintln(buf, err)
+			continue
+		}
+		if len(bs) > 72 {
+			bs = append(bs[:69], "..."...)
+			buf.Write(bs)
+		} else {
+			rend.write(buf, bs)
+		}
+		fmt.Fprintln(buf)
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// testSession returns a session connected to a fake PSM that answers each
+// command with the result and error code returned by the handler.
+func testSession(handler func(cmd command) (interface{}, int)) (*session, *bytes.Buffer) {
+	client, server := net.Pipe()
+	// Responses are written separately from reading commands, so that
+	// several commands can be outstanding as with a real connection.
+	responses := make(chan response, 1000)
+	go func() {
+		defer close(responses)
+		dec := json.NewDecoder(server)
+		dec.UseNumber()
+		for {
+			var cmd command
+			if err := dec.Decode(&cmd); err != nil {
+				return
+			}
+			var res response
+			res.ID = cmd.ID
+			res.Result, res.Error.Code = handler(cmd)
+			if res.Error.Code != 0 {
+				res.Error.Message = "failed"
+			}
+			responses <- res
+		}
+	}()
+	go func() {
+		defer server.Close()
+		enc := json.NewEncoder(server)
+		for res := range responses {
+			if err := enc.Encode(res); err != nil {
+				return
+			}
+		}
+	}()
+
+	dec := json.NewDecoder(client)
+	dec.UseNumber()
+	out := new(bytes.Buffer)
+	s := &session{
+		conn:     &connection{conn: client, enc: json.NewEncoder(client), dec: dec},
+		out:      out,
+		esc:      &terminal.EscapeCodes{},
+		renderer: jsonRenderer{format: formatJSON},
+		vars:     make(map[string]interface{}),
+	}
+	return s, out
+}
+
+// recordingHandler records the called methods; methods named "fail" fail.
+func recordingHandler(methods *[]string) func(cmd command) (interface{}, int) {
+	return func(cmd command) (interface{}, int) {
+		*methods = append(*methods, cmd.Method)
+		if strings.HasSuffix(cmd.Method, ".fail") {
+			return nil, -1
+		}
+		return cmd.Params, 0
+	}
+}
+
+// fakePSM is an object store for testSession, answering the list, create,
+// updateByAid and deleteByAid methods and system.version and
+// system.hostname. The changing calls are recorded.
+type fakePSM struct {
+	mut        sync.Mutex
+	hostname   string
+	objects    map[string][]map[string]interface{}
+	nextOid    int
+	calls      []string
+	createTrue bool // object.create returns true instead of the OID
+}
+
+func (f *fakePSM) handle(cmd command) (interface{}, int) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	switch {
+	case cmd.Method == "system.version":
+		return "15.0", 0
+
+	case cmd.Method == "system.hostname":
+		return f.hostname, 0
+
+	case strings.HasSuffix(cmd.Method, ".list"):
+		objs := f.objects[strings.TrimSuffix(cmd.Method, ".list")]
+		limit, _ := cmd.Params[0].(json.Number).Int64()
+		offset, _ := cmd.Params[1].(json.Number).Int64()
+		res := []interface{}{}
+		for i := offset; i < offset+limit && i < int64(len(objs)); i++ {
+			res = append(res, objs[i])
+		}
+		return res, 0
+
+	case cmd.Method == methodCreate:
+		f.calls = append(f.calls, fmt.Sprint(cmd.Method, " ", cmd.Params))
+		typ := cmd.Params[0].(string)
+		obj := make(map[string]interface{})
+		for k, v := range cmd.Params[1].(map[string]interface{}) {
+			obj[k] = v
+		}
+		if f.objects == nil {
+			f.objects = make(map[string][]map[string]interface{})
+		}
+		f.nextOid++
+		obj["oid"] = json.Number(fmt.Sprint(f.nextOid))
+		f.objects[typ] = append(f.objects[typ], obj)
+		if f.createTrue {
+			return true, 0
+		}
+		return obj["oid"], 0
+
+	case cmd.Method == methodUpdateByAid:
+		f.calls = append(f.calls, fmt.Sprint(cmd.Method, " ", cmd.Params))
+		typ := cmd.Params[0].(string)
+		for _, obj := range f.objects[typ] {
+			if objectAid(typ, obj) == cmd.Params[1] {
+				for k, v := range cmd.Params[2].(map[string]interface{}) {
+					obj[k] = v
+				}
+				return nil, 0
+			}
+		}
+
+	case cmd.Method == methodDeleteByAid:
+		f.calls = append(f.calls, fmt.Sprint(cmd.Method, " ", cmd.Params))
+		typ := cmd.Params[0].(string)
+		for i, obj := range f.objects[typ] {
+			if objectAid(typ, obj) == cmd.Params[1] {
+				f.objects[typ] = append(f.objects[typ][:i], f.objects[typ][i+1:]...)
+				return nil, 0
+			}
+		}
+	}
+
+	return nil, -1
+}
+
+func TestExecuteChain(t *testing.T) {
+	testcases := []struct {
+		line    string
+		methods []string
+		ok      bool
+	}{
+		{"a ok", []string{"a.ok"}, true},
+		{"a fail", []string{"a.fail"}, false},
+		{"a ok && b ok", []string{"a.ok", "b.ok"}, true},
+		{"a fail && b ok", []string{"a.fail"}, false},
+		{"a fail || b ok", []string{"a.fail", "b.ok"}, true},
+		{"a ok || b ok", []string{"a.ok"}, true},
+		{"a ok && b fail && c ok; d ok || e ok", []string{"a.ok", "b.fail", "d.ok"}, true},
+		{"a fail; b fail", []string{"a.fail", "b.fail"}, false},
+		{"a fail && b ok || c ok", []string{"a.fail", "c.ok"}, true},
+		{"a ok && bad", []string{"a.ok"}, false},
+	}
+
+	for _, tc := range testcases {
+		var methods []string
+		s, _ := testSession(recordingHandler(&methods))
+		ok, err := s.execute(tc.line)
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %v", tc.line, err)
+			continue
+		}
+		if ok != tc.ok || !reflect.DeepEqual(methods, tc.methods) {
+			t.Errorf("Incorrect execution of %q: %v %q != %v %q", tc.line, ok, methods, tc.ok, tc.methods)
+		}
+	}
+}
+
+func TestExecuteChainVariables(t *testing.T) {
+	var methods []string
+	s, out := testSession(recordingHandler(&methods))
+	if ok, err := s.execute("set x = a ok 42 && b ok $x[0]"); !ok || err != nil {
+		t.Fatalf("Unexpected failure: %v %v\n%s", ok, err, out)
+	}
+	if out.String() != "42\n" {
+		t.Errorf("Incorrect output %q", out.String())
+	}
+}
+
+func TestRunScript(t *testing.T) {
+	script := `# A comment
+
+a ok {"x":
+  1}
+b fail ||
+  c ok
+d fail
+`
+
+	var methods []string
+	s, _ := testSession(recordingHandler(&methods))
+	code, err := s.runScript(strings.NewReader(script))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != 1 {
+		t.Errorf("Incorrect exit code %d for script ending with a failed command", code)
+	}
+	if exp := []string{"a.ok", "b.fail", "c.ok", "d.fail"}; !reflect.DeepEqual(methods, exp) {
+		t.Errorf("Incorrect methods %q != %q", methods, exp)
+	}
+}
+
+func TestForeach(t *testing.T) {
+	subs := []interface{}{
+		map[string]interface{}{"subscriberId": "a", "persistent": true},
+		map[string]interface{}{"subscriberId": "b", "persistent": false},
+		map[string]interface{}{"subscriberId": "c", "persistent": true},
+	}
+
+	var calls []string
+	s, out := testSession(func(cmd command) (interface{}, int) {
+		if cmd.Method == "subscriber.list" {
+			return subs, 0
+		}
+		calls = append(calls, fmt.Sprint(cmd.Method, cmd.Params))
+		if cmd.Method == "object.updateByAid" && cmd.Params[0] == "c" {
+			return nil, -1
+		}
+		return nil, 0
+	})
+
+	ok, err := s.execute("foreach x in (subscriber list 1000 | where persistent == true) do object updateByAid $x.subscriberId persistent=false")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Error("Unexpected success with a failed item")
+	}
+	exp := []string{"object.updateByAid[a map[persistent:false]]", "object.updateByAid[c map[persistent:false]]"}
+	if !reflect.DeepEqual(calls, exp) {
+		t.Errorf("Incorrect calls %q != %q", calls, exp)
+	}
+	if !strings.Contains(out.String(), "[2/2]") || !strings.Contains(out.String(), "1 of 2 items failed") {
+		t.Errorf("Missing progress or failure report in output:\n%s", out)
+	}
+
+	// The limit on mutating calls stops the loop
+
+	calls = nil
+	out.Reset()
+	s.vars["subs"] = subs
+	ok, _ = s.execute("foreach x in $subs do object get $x.subscriberId && object delete $x.subscriberId")
+	if !ok || len(calls) != 6 {
+		t.Errorf("Incorrect result of loop within the limit: %v %q", ok, calls)
+	}
+
+	calls = nil
+	ok, _ = s.execute("foreach --max=1 x in $subs do object delete $x.subscriberId")
+	if ok || len(calls) != 1 || !strings.Contains(out.String(), "stopped before item 2 of 3") {
+		t.Errorf("Incorrect result of loop over the limit: %v %q\n%s", ok, calls, out)
+	}
+
+	for _, line := range []string{"foreach x", "foreach x in (subscriber list", "foreach x in $subs", "foreach x in $subs[0] do system hostname"} {
+		if ok, _ := s.execute(line); ok {
+			t.Errorf("Unexpected success of %q", line)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+// psmcli
+// Copyright (C) 2014 Procera Networks, Inc.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// snapshotFormat is the version of the snapshot file format.
+const snapshotFormat = 1
+
+// A snapshot file is a header line followed by one line per object, each
+// a JSON object:
+//
+//	{"psmsnap":1,"hostname":"psm1","version":"15.0.5","time":"...","types":["subscriber"]}
+//	{"type":"subscriber","object":{"oid":288230376151715606,...}}
+type snapshotHeader struct {
+	Format   int      `json:"psmsnap"`
+	Hostname string   `json:"hostname"`
+	Version  string   `json:"version"`
+	Time     string   `json:"time"`
+	Types    []string `json:"types"`
+}
+
+func (h snapshotHeader) String() string {
+	return strings.TrimSpace(fmt.Sprintf("%s (PSM %s) %s", h.Hostname, h.Version, h.Time))
+}
+
+// typedObject is an object and its type.
+type typedObject struct {
+	Type   string                 `json:"type"`
+	Object map[string]interface{} `json:"object"`
+}
+
+// callString returns the string result of the method without parameters,
+// or "(unknown)".
+func (s *session) callString(method string) (string, error) {
+	res, err := s.conn.run(command{ID: s.id, Method: method})
+	s.id++
+	if err != nil {
+		return "", err
+	}
+	if str, ok := res.Result.(string); ok && res.Error.Code == 0 {
+		return str, nil
+	}
+	return "(unknown)", nil
+}
+
+// snapshot writes all objects of the types, or of all types listable
+// according to the SMD, to w. The number of objects of each type is
+// printed as they are written.
+func (s *session) snapshot(w io.Writer, types []string, now time.Time) (bool, error) {
+	if len(types) == 0 {
+		types = objectTypes(s.services)
+	}
+
+	hdr := snapshotHeader{Format: snapshotFormat, Time: now.UTC().Format(time.RFC3339), Types: types}
+	var err error
+	if hdr.Version, err = s.callString("system.version"); err != nil {
+		return false, err
+	}
+	if hdr.Hostname, err = s.callString("system.hostname"); err != nil {
+		return false, err
+	}
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(hdr); err != nil {
+		fmt.Fprintln(s.out, err)
+		return false, nil
+	}
+
+	for _, typ := range types {
+		p := newProgress(s.out, typ+" objects", 0)
+		ok, err := s.listObjects(typ, defaultPageSize, func(obj map[string]interface{}) error {
+			p.done(true)
+			return enc.Encode(typedObject{typ, obj})
+		})
+		if err != nil || !ok {
+			return false, err
+		}
+		p.finish()
+	}
+	return true, nil
+}
+
+// readSnapshot reads a snapshot file.
+func readSnapshot(r io.Reader) (snapshotHeader, []typedObject, error) {
+	var hdr snapshotHeader
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	if !sc.Scan() {
+		if err := sc.Err(); err != nil {
+			return hdr, nil, err
+		}
+		return hdr, nil, fmt.Errorf("empty snapshot")
+	}
+	if err := json.Unmarshal(sc.Bytes(), &hdr); err != nil || hdr.Format == 0 {
+		return hdr, nil, fmt.Errorf("not a psmcli snapshot")
+	}
+	if hdr.Format > snapshotFormat {
+		return hdr, nil, fmt.Errorf("snapshot format %d is newer than this psmcli supports", hdr.Format)
+	}
+
+	var objs []typedObject
+	for num := 2; sc.Scan(); num++ {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		dec := json.NewDecoder(bytes.NewReader(sc.Bytes()))
+		dec.UseNumber()
+		var obj typedObject
+		if err := dec.Decode(&obj); err != nil || obj.Type == "" || obj.Object == nil {
+			return hdr, nil, fmt.Errorf("line %d: not a snapshot object", num)
+		}
+		objs = append(objs, obj)
+	}
+	return hdr, objs, sc.Err()
+}
+
+// readSnapshotFile reads the named snapshot file, or standard input for
+// "-".
+func readSnapshotFile(name string) (snapshotHeader, []typedObject, error) {
+	if name == "-" {
+		return readSnapshot(os.Stdin)
+	}
+	fd, err := os.Open(name)
+	if err != nil {
+		return snapshotHeader{}, nil, err
+	}
+	defer fd.Close()
+	hdr, objs, err := readSnapshot(fd)
+	if err != nil {
+		err = fmt.Errorf("%s: %v", name, err)
+	}
+	return hdr, objs, err
+}
+
+// snapshotMain runs "psmcli snapshot", writing a snapshot of the node to
+// standard output or a file.
+func snapshotMain(args []string, user string, verbose bool) int {
+	fs := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	types := fs.String("types", "", "Object types to include, comma separated (default all)")
+	output := fs.String("o", "", "Write the snapshot to the file instead of standard output")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: psmcli [options] snapshot [-types=a,b] [-o file] <host:port | profile>")
+		return 2
+	}
+
+	s, err := connectBatch(fs.Arg(0), user, os.Stderr, verbose)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	var typeList []string
+	if *types != "" {
+		typeList = strings.Split(*types, ",")
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		if out, err = os.Create(*output); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer out.Close()
+	}
+
+	bw := bufio.NewWriter(out)
+	ok, err := s.snapshot(bw, typeList, time.Now())
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if !ok {
+		return 1
+	}
+	return 0
+}
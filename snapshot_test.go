@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestObjectTypes(t *testing.T) {
+	services := map[string]smdService{
+		"subscriber.list":  {Parameters: []smdParameter{{Name: "limit"}, {Name: "offset", Optional: true}}},
+		"subscriber.get":   {},
+		"group.list":       {},
+		"session.list":     {Parameters: []smdParameter{{Name: "subscriberId"}}},
+		"object.list":      {Parameters: []smdParameter{{Name: "type", Optional: true}}},
+		"system.hostname":  {},
+		"tunnel.listAll":   {},
+		"group.listMember": {},
+	}
+
+	types := objectTypes(services)
+	exp := []string{"group", "subscriber"}
+	if !reflect.DeepEqual(types, exp) {
+		t.Errorf("Incorrect object types %q != expected %q", types, exp)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	f := &fakePSM{hostname: "psm1", objects: map[string][]map[string]interface{}{
+		"group":      {{"oid": json.Number("10"), "aid": "g1"}},
+		"subscriber": {{"oid": json.Number("1"), "subscriberId": "a", "parentOid": json.Number("10")}},
+	}}
+	s, _ := testSession(f.handle)
+	s.services = map[string]smdService{
+		"group.list":      {Parameters: []smdParameter{{Name: "limit"}, {Name: "offset"}}},
+		"subscriber.list": {Parameters: []smdParameter{{Name: "limit"}, {Name: "offset"}}},
+	}
+
+	var buf bytes.Buffer
+	ok, err := s.snapshot(&buf, nil, time.Date(2014, 10, 25, 10, 0, 0, 0, time.UTC))
+	if err != nil || !ok {
+		t.Fatalf("Unexpected snapshot failure: %v", err)
+	}
+
+	exp := `{"psmsnap":1,"hostname":"psm1","version":"15.0","time":"2014-10-25T10:00:00Z","types":["group","subscriber"]}
+{"type":"group","object":{"aid":"g1","oid":10}}
+{"type":"subscriber","object":{"oid":1,"parentOid":10,"subscriberId":"a"}}
+`
+	if buf.String() != exp {
+		t.Errorf("Incorrect snapshot:\n%s\n!= expected\n%s", buf.String(), exp)
+	}
+
+	hdr, objs, err := readSnapshot(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Hostname != "psm1" || hdr.Version != "15.0" || len(objs) != 2 || objs[1].Object["parentOid"] != json.Number("10") {
+		t.Errorf("Incorrect snapshot read back: %+v %v", hdr, objs)
+	}
+}
+
+func TestReadSnapshot(t *testing.T) {
+	testcases := []string{
+		"",
+		"{}\n",
+		"[1]\n",
+		`{"psmsnap":2}` + "\n",
+		`{"psmsnap":1}` + "\n" + `{"object":{}}` + "\n",
+		`{"psmsnap":1}` + "\n" + "{bad\n",
+	}
+
+	for _, tc := range testcases {
+		if _, _, err := readSnapshot(strings.NewReader(tc)); err == nil {
+			t.Errorf("Unexpected nil error for %q", tc)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+// psmcli
+// Copyright (C) 2014 Procera Networks, Inc.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// validateCommand checks the command against the method descriptions in
+// the SMD, returning an error describing what's wrong and the expected
+// signature if the method is unknown, a required parameter is missing,
+// there are too many parameters or a parameter has the wrong type.
+func validateCommand(cmd command, services map[string]smdService) error {
+	if len(services) == 0 {
+		// Nothing to validate against
+		return nil
+	}
+
+	svc, ok := services[cmd.Method]
+	if !ok {
+		msg := "unknown method " + strings.Replace(cmd.Method, ".", " ", 1)
+		if sugg := suggestMethods(cmd.Method, services); len(sugg) > 0 {
+			msg += "; did you mean " + strings.Join(sugg, " or ") + "?"
+		}
+		return fmt.Errorf("%s", msg)
+	}
+
+	var problem string
+	switch {
+	case len(cmd.Params) > len(svc.Parameters):
+		problem = fmt.Sprintf("too many parameters; expected at most %d, got %d", len(svc.Parameters), len(cmd.Params))
+
+	default:
+		for i, p := range svc.Parameters {
+			if i >= len(cmd.Params) {
+				if !p.Optional {
+					problem = "missing required parameter " + p.Name
+				}
+				break
+			}
+			v := cmd.Params[i]
+			if v == nil && p.Optional {
+				continue
+			}
+			if !typeMatches(v, p.Type) {
+				problem = fmt.Sprintf("parameter %s should be %s, not %s", p.Name, withArticle(p.Type), withArticle(typeName(v)))
+				break
+			}
+		}
+	}
+
+	if problem == "" {
+		return nil
+	}
+	return fmt.Errorf("%s\nUsage: %s", problem, signature(cmd.Method, svc))
+}
+
+// signature returns the method and its parameters, in the style of the tab
+// completion placeholders.
+func signature(method string, svc smdService) string {
+	parts := []string{strings.Replace(method, ".", " ", 1)}
+	for _, p := range svc.Parameters {
+		if p.Optional {
+			parts = append(parts, "["+p.Name+" ("+p.Type+")]")
+		} else {
+			parts = append(parts, "<"+p.Name+" ("+p.Type+")>")
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// typeMatches returns true if the value is acceptable for the named JSON
+// type. Unknown types accept anything.
+func typeMatches(v interface{}, typ string) bool {
+	switch typ {
+	case "integer":
+		n, ok := v.(json.Number)
+		if !ok {
+			return false
+		}
+		return !strings.ContainsAny(string(n), ".eE")
+	case "number":
+		_, ok := v.(json.Number)
+		return ok
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "string":
+		switch v.(type) {
+		case string, typedString:
+			return true
+		}
+		return false
+	case "object":
+		switch v.(type) {
+		case map[string]interface{}, attributes:
+			return true
+		}
+		return false
+	case "array":
+		_, ok := v.([]interface{})
+		return ok
+	}
+	return true
+}
+
+// typeName returns the JSON type name of the value.
+func typeName(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case json.Number:
+		if strings.ContainsAny(string(v), ".eE") {
+			return "number"
+		}
+		return "integer"
+	case bool:
+		return "boolean"
+	case string, typedString:
+		return "string"
+	case map[string]interface{}, attributes:
+		return "object"
+	case []interface{}:
+		return "array"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func withArticle(s string) string {
+	switch s {
+	case "null":
+		return s
+	case "integer", "object", "array":
+		return "an " + s
+	}
+	return "a " + s
+}
+
+// suggestMethods returns up to three known methods that are similar to the
+// given one, most similar first, in command line form.
+func suggestMethods(method string, services map[string]smdService) []string {
+	type candidate struct {
+		name string
+		dist int
+	}
+
+	var cands []candidate
+	lower := strings.ToLower(method)
+	for name := range services {
+		d := editDistance(lower, strings.ToLower(name))
+		if d <= len(method)/3+1 || strings.HasSuffix(name, "."+methodPart(method)) {
+			cands = append(cands, candidate{name, d})
+		}
+	}
+
+	sort.Slice(cands, func(a, b int) bool {
+		if cands[a].dist != cands[b].dist {
+			return cands[a].dist < cands[b].dist
+		}
+		return cands[a].name < cands[b].name
+	})
+
+	var res []string
+	for i := 0; i < len(cands) && i < 3; i++ {
+		res = append(res, strings.Replace(cands[i].name, ".", " ", 1))
+	}
+	return res
+}
+
+// methodPart returns the part of the method name after the service.
+func methodPart(method string) string {
+	if i := strings.IndexByte(method, '.'); i >= 0 {
+		return method[i+1:]
+	}
+	return method
+}
+
+// editDistance returns the Levenshtein distance between the strings.
+func editDistance(a, b string) int {
+	ar, br := []rune(a), []rune(b)
+	prev := make([]int, len(br)+1)
+	cur := make([]int, len(br)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ar); i++ {
+		cur[0] = i
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(br)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
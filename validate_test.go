@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateCommand(t *testing.T) {
+	services := map[string]smdService{
+		"subscriber.list": {
+			Parameters: []smdParameter{
+				{Name: "count", Type: "integer"},
+			},
+		},
+		"subscriber.getByAid": {
+			Parameters: []smdParameter{
+				{Name: "aid", Type: "string"},
+			},
+		},
+		"object.updateByAid": {
+			Parameters: []smdParameter{
+				{Name: "type", Type: "string"},
+				{Name: "aid", Type: "string"},
+				{Name: "attributes", Type: "object"},
+				{Name: "create", Type: "boolean", Optional: true},
+			},
+		},
+	}
+
+	testcases := []struct {
+		line string
+		err  string // prefix of the error, or empty for none
+	}{
+		{"subscriber list 10", ""},
+		{"object updateByAid subscriber 1234 a=b", ""},
+		{"object updateByAid subscriber 1234 a=b true", ""},
+		{"object updateByAid subscriber 1234 a=b null", ""},
+		{"subscriber lst 10", "unknown method subscriber lst; did you mean subscriber list?"},
+		{"subscribers getByAid 10", "unknown method subscribers getByAid; did you mean subscriber getByAid?"},
+		{"subscriber list", "missing required parameter count\nUsage: subscriber list <count (integer)>"},
+		{"subscriber list 1 2", "too many parameters; expected at most 1, got 2"},
+		{"subscriber getByAid [1,2]", "parameter aid should be a string, not an array"},
+		{"object updateByAid subscriber 1234 [1]", "parameter attributes should be an object, not an array\n" +
+			"Usage: object updateByAid <type (string)> <aid (string)> <attributes (object)> [create (boolean)]"},
+	}
+
+	for _, tc := range testcases {
+		cmd, err := parseCommand(tc.line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := coerceParams(&cmd, services, objectAttributeTypes); err != nil {
+			t.Fatal(err)
+		}
+		err = validateCommand(cmd, services)
+		switch {
+		case tc.err == "" && err != nil:
+			t.Errorf("Unexpected error for %q: %v", tc.line, err)
+		case tc.err != "" && err == nil:
+			t.Errorf("Unexpected nil error for %q", tc.line)
+		case tc.err != "" && !strings.HasPrefix(err.Error(), tc.err):
+			t.Errorf("Incorrect error for %q:\n\t%s\n\t%s", tc.line, err, tc.err)
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	testcases := []struct {
+		a, b string
+		d    int
+	}{
+		{"", "", 0},
+		{"abc", "abc", 0},
+		{"abc", "ab", 1},
+		{"kitten", "sitting", 3},
+		{"subscriber.lst", "subscriber.list", 1},
+	}
+
+	for _, tc := range testcases {
+		if d := editDistance(tc.a, tc.b); d != tc.d {
+			t.Errorf("Incorrect distance between %q and %q: %d != %d", tc.a, tc.b, d, tc.d)
+		}
+	}
+}
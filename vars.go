@@ -0,0 +1,188 @@
+// psmcli
+// Copyright (C) 2014 Procera Networks, Inc.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// A variable reference is $name or ${name}, optionally followed by a path
+// of .key and [index] elements, e.g. $_.oid or $subs[0].subscriberId.
+var (
+	refExp  = regexp.MustCompile(`^\$(?:\{([^}]*)\}|([A-Za-z_][A-Za-z0-9_]*(?:\.[A-Za-z_][A-Za-z0-9_-]*|\[[0-9]+\])*))`)
+	pathExp = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)((?:\.[A-Za-z_][A-Za-z0-9_-]*|\[[0-9]+\])*)$`)
+	elemExp = regexp.MustCompile(`\.([A-Za-z_][A-Za-z0-9_-]*)|\[([0-9]+)\]`)
+	safeExp = regexp.MustCompile(`^[A-Za-z0-9_.:/+-]+$`)
+)
+
+// lookupVar returns the value of the variable reference if s is exactly
+// one reference, and false otherwise.
+func lookupVar(s string, vars map[string]interface{}) (interface{}, bool, error) {
+	m := refExp.FindStringSubmatch(s)
+	if m == nil || len(m[0]) != len(s) {
+		return nil, false, nil
+	}
+	v, err := evalRef(m, vars)
+	return v, true, err
+}
+
+// expandVars replaces the variable references in the line with their
+// values, formatted so that they parse back as the same value: within
+// double quotes as the plain string, within JSON as JSON, within LDAP
+// filters as is and elsewhere quoted as necessary. References within
+// single quotes or preceded by a backslash are left alone; as in
+// parseCommand, quotes and backslashes have no special meaning within
+// parentheses.
+func expandVars(line string, vars map[string]interface{}) (string, error) {
+	if !strings.Contains(line, "$") {
+		return line, nil
+	}
+
+	var buf bytes.Buffer
+	var quote rune
+	escaped := false
+	depth, parens := 0, 0
+	for i := 0; i < len(line); {
+		r, w := utf8.DecodeRuneInString(line[i:])
+
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\' && quote != '\'' && parens == 0:
+			escaped = true
+		case r == '$' && quote != '\'':
+			m := refExp.FindStringSubmatch(line[i:])
+			if m == nil {
+				break
+			}
+			v, err := evalRef(m, vars)
+			if err != nil {
+				return "", err
+			}
+			switch {
+			case quote == '"':
+				s := strings.Replace(plainString(v), `\`, `\\`, -1)
+				buf.WriteString(strings.Replace(s, `"`, `\"`, -1))
+			case parens > 0:
+				buf.WriteString(plainString(v))
+			case depth > 0:
+				bs, _ := json.Marshal(v)
+				buf.Write(bs)
+			default:
+				buf.WriteString(shellWord(v))
+			}
+			i += len(m[0])
+			continue
+		case parens > 0 && r == '(':
+			parens++
+		case parens > 0 && r == ')':
+			parens--
+		case parens > 0:
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '{' || r == '[':
+			depth++
+		case r == '}' || r == ']':
+			depth--
+		case r == '(':
+			parens++
+		}
+
+		buf.WriteRune(r)
+		i += w
+	}
+
+	return buf.String(), nil
+}
+
+// evalRef returns the value of the variable reference matched by refExp.
+func evalRef(m []string, vars map[string]interface{}) (interface{}, error) {
+	expr := m[2]
+	if expr == "" {
+		expr = strings.TrimSpace(m[1])
+	}
+
+	pm := pathExp.FindStringSubmatch(expr)
+	if pm == nil {
+		return nil, fmt.Errorf("bad variable reference %s", m[0])
+	}
+
+	v, ok := vars[pm[1]]
+	if !ok {
+		return nil, fmt.Errorf("undefined variable $%s", pm[1])
+	}
+
+	return walkPath(v, pm[2], "$"+pm[1])
+}
+
+// walkPath returns the element of v at the path of .key and [index]
+// elements. The name is used in error messages.
+func walkPath(v interface{}, path, name string) (interface{}, error) {
+	for _, em := range elemExp.FindAllStringSubmatch(path, -1) {
+		name += em[0]
+		if em[1] != "" {
+			obj, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s: not an object", name)
+			}
+			if v, ok = obj[em[1]]; !ok {
+				return nil, fmt.Errorf("%s: no such member", name)
+			}
+		} else {
+			list, ok := v.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s: not a list", name)
+			}
+			idx, _ := strconv.Atoi(em[2])
+			if idx >= len(list) {
+				return nil, fmt.Errorf("%s: index out of range, the list has %d elements", name, len(list))
+			}
+			v = list[idx]
+		}
+	}
+
+	return v, nil
+}
+
+// plainString returns strings as is and other values as JSON.
+func plainString(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	bs, _ := json.Marshal(v)
+	return string(bs)
+}
+
+// shellWord returns the value as a word that parseParam turns back into
+// the same value.
+func shellWord(v interface{}) string {
+	s, ok := v.(string)
+	if !ok {
+		return plainString(v)
+	}
+
+	special := !safeExp.MatchString(s) || strings.HasPrefix(s, "--")
+	switch s {
+	case "null", "true", "false":
+		special = true
+	}
+	if _, typed, _ := parseTyped(s); typed {
+		special = true
+	}
+	if !special {
+		return s
+	}
+
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
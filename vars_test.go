@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func testVars() map[string]interface{} {
+	return map[string]interface{}{
+		"_":    map[string]interface{}{"oid": json.Number("1234"), "name": "it's here"},
+		"subs": []interface{}{map[string]interface{}{"subscriberId": "sub-1"}},
+		"n":    json.Number("42"),
+		"b":    "true",
+	}
+}
+
+func TestExpandVars(t *testing.T) {
+	testcases := []struct {
+		in, out string
+	}{
+		{"subscriber get $_.oid", "subscriber get 1234"},
+		{"subscriber get ${_.oid}", "subscriber get 1234"},
+		{"subscriber get $subs[0].subscriberId", "subscriber get sub-1"},
+		{"object get $n name=$_.name", "object get 42 name='it'\\''s here'"},
+		{`object get "$_.name"`, `object get "it's here"`},
+		{`object get '$_.name'`, `object get '$_.name'`},
+		{`object get \$n`, `object get \$n`},
+		{`object update {"oid": $_.oid, "name": $_.name}`, `object update {"oid": 1234, "name": "it's here"}`},
+		{`object find (name=$subs[0].subscriberId)`, `object find (name=sub-1)`},
+		{`object find (&(name=it's)(id=$n))`, `object find (&(name=it's)(id=42))`},
+		{`object find (name="a)(id=$n)`, `object find (name="a)(id=42)`},
+		{"system echo $b", "system echo 'true'"},
+		{"system echo $_", `system echo {"name":"it's here","oid":1234}`},
+		{"system echo 5$", "system echo 5$"},
+	}
+
+	for _, tc := range testcases {
+		out, err := expandVars(tc.in, testVars())
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %v", tc.in, err)
+			continue
+		}
+		if out != tc.out {
+			t.Errorf("Incorrect expansion of %q: %q != %q", tc.in, out, tc.out)
+		}
+	}
+}
+
+func TestExpandVarsRoundTrip(t *testing.T) {
+	// Expanded values parse back to the variable value
+	for _, name := range []string{"_.name", "subs[0].subscriberId", "b"} {
+		line, err := expandVars("system echo $"+name, testVars())
+		if err != nil {
+			t.Fatal(err)
+		}
+		cmd, err := parseCommand(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		exp, _, _ := lookupVar("$"+name, testVars())
+		if !reflect.DeepEqual(cmd.Params, []interface{}{exp}) {
+			t.Errorf("Incorrect round trip of $%s: %#v != %#v", name, cmd.Params, exp)
+		}
+	}
+}
+
+func TestExpandVarsErrors(t *testing.T) {
+	testcases := []string{
+		"subscriber get $x",
+		"subscriber get $_.missing",
+		"subscriber get $subs[1]",
+		"subscriber get $n.oid",
+		"subscriber get $_[0]",
+		"subscriber get ${_ oid}",
+	}
+
+	for _, tc := range testcases {
+		if _, err := expandVars(tc, testVars()); err == nil {
+			t.Errorf("Missing error for %q", tc)
+		}
+	}
+}
+
+func TestLookupVar(t *testing.T) {
+	v, ok, err := lookupVar("$_.oid", testVars())
+	if !ok || err != nil || v != json.Number("1234") {
+		t.Errorf("Incorrect lookup: %v %v %v", v, ok, err)
+	}
+	if _, ok, _ := lookupVar("subscriber get $_.oid", testVars()); ok {
+		t.Error("Unexpected lookup of a command")
+	}
+}
@@ -0,0 +1,76 @@
+// psmcli
+// Copyright (C) 2014 Procera Networks, Inc.
+
+package main
+
+import (
+	"encoding/json"
+
+	"gopkg.in/yaml.v2"
+)
+
+// parseYAML parses a YAML document into the types parseJSON returns;
+// mappings as map[string]interface{} and numbers as json.Number. Numbers
+// not written as JSON numbers, such as 01234 or .5, are kept as strings.
+// Keys given more than once are an error.
+func parseYAML(data []byte) (interface{}, error) {
+	var v yamlValue
+	if err := yaml.UnmarshalStrict(data, &v); err != nil {
+		return nil, err
+	}
+	return v.v, nil
+}
+
+// yamlValue is a YAML value converted to the types used for JSON.
+type yamlValue struct {
+	v interface{}
+}
+
+func (y *yamlValue) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var m map[string]yamlValue
+	mapErr := unmarshal(&m)
+	if mapErr == nil {
+		res := make(map[string]interface{}, len(m))
+		for k, e := range m {
+			res[k] = e.v
+		}
+		y.v = res
+		return nil
+	}
+
+	var l []yamlValue
+	if err := unmarshal(&l); err == nil {
+		res := make([]interface{}, len(l))
+		for i, e := range l {
+			res[i] = e.v
+		}
+		y.v = res
+		return nil
+	}
+
+	var v interface{}
+	if err := unmarshal(&v); err != nil {
+		return err
+	}
+	switch v.(type) {
+	case map[interface{}]interface{}:
+		// A mapping that isn't valid, e.g. with a key given twice
+		return mapErr
+
+	case int, int64, uint64, float64:
+		// Read again as a string to get the number as written
+		var s string
+		if err := unmarshal(&s); err != nil {
+			return err
+		}
+		if isJSONNumber(s) {
+			y.v = json.Number(s)
+		} else {
+			y.v = s
+		}
+
+	default:
+		y.v = v
+	}
+	return nil
+}
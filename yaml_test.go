@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	doc := `---
+# A subscriber
+subscriberId: "20:c9:d0:43:0c:95"
+hostName: syno-2 # trailing comment
+persistent: false
+slot: 80
+oid: 288230376151715606
+parentOid: ~
+ratio: 1.5
+note: 'it''s # not a comment'
+tags: [a, "b c"]
+nested:
+  a: 1
+  b:
+    - x
+    - y: 2
+      z: 3
+    -
+      - deep
+list:
+- one
+- two
+empty:
+`
+
+	exp := map[string]interface{}{
+		"subscriberId": "20:c9:d0:43:0c:95",
+		"hostName":     "syno-2",
+		"persistent":   false,
+		"slot":         json.Number("80"),
+		"oid":          json.Number("288230376151715606"),
+		"parentOid":    nil,
+		"ratio":        json.Number("1.5"),
+		"note":         "it's # not a comment",
+		"tags":         []interface{}{"a", "b c"},
+		"nested": map[string]interface{}{
+			"a": json.Number("1"),
+			"b": []interface{}{
+				"x",
+				map[string]interface{}{"y": json.Number("2"), "z": json.Number("3")},
+				[]interface{}{"deep"},
+			},
+		},
+		"list":  []interface{}{"one", "two"},
+		"empty": nil,
+	}
+
+	v, err := parseYAML([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(v, exp) {
+		t.Errorf("Incorrect parse:\n\t%#v\n\t%#v", v, exp)
+	}
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+	for _, doc := range []string{
+		"a: 1\na: 2\n",
+		"a: 1\n  b: 2\n",
+		"a: [1, 2\n",
+		"a: 1\n\tb: 2\n",
+	} {
+		if _, err := parseYAML([]byte(doc)); err == nil {
+			t.Errorf("Unexpected nil error for %q", doc)
+		}
+	}
+}
+
+func TestParseYAMLNumbers(t *testing.T) {
+	testcases := []struct {
+		yaml string
+		v    interface{}
+	}{
+		{"80", json.Number("80")},
+		{"-1.5e3", json.Number("-1.5e3")},
+		{"01234", "01234"},
+		{".5", ".5"},
+		{"1.", "1."},
+		{"+1", "+1"},
+		{"0x1f", "0x1f"},
+		{".inf", ".inf"},
+		{`"80"`, "80"},
+	}
+
+	for _, tc := range testcases {
+		v, err := parseYAML([]byte("a: " + tc.yaml + "\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a := v.(map[string]interface{})["a"]; a != tc.v {
+			t.Errorf("Incorrect value for %s: %#v != expected %#v", tc.yaml, a, tc.v)
+		}
+	}
+}